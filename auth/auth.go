@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/sammy007/monero-stratum/pool"
+)
+
+type Role int
+
+const (
+	Anonymous Role = iota
+	Viewer
+	Operator
+	Admin
+)
+
+var roleNames = []string{"anonymous", "viewer", "operator", "admin"}
+
+func (r Role) String() string {
+	return roleNames[r]
+}
+
+func ParseRole(name string) (Role, error) {
+	for i, v := range roleNames[1:] {
+		if v == name {
+			return Role(i + 1), nil
+		}
+	}
+	return Anonymous, fmt.Errorf("unknown role %q", name)
+}
+
+type user struct {
+	name     string
+	role     Role
+	password string
+	token    []byte
+	// Legacy frontend login keeps plain password
+	plain bool
+}
+
+type contextKey struct{}
+
+// Authenticator resolves role of request from basic auth or bearer token and guards route groups
+type Authenticator struct {
+	public      bool
+	users       map[string]*user
+	tokens      []*user
+	credentials *credentialCache
+}
+
+// New builds authenticator of frontend, without any user or legacy password nobody could sign in,
+// so stats are public then like they used to be with empty password
+func New(cfg *pool.Frontend) (*Authenticator, error) {
+	public := cfg.Public || (len(cfg.Users) == 0 && len(cfg.Password) == 0)
+	a := &Authenticator{public: public, users: make(map[string]*user), credentials: newCredentialCache()}
+	for _, v := range cfg.Users {
+		role, err := ParseRole(v.Role)
+		if err != nil {
+			return nil, fmt.Errorf("user %s: %v", v.Name, err)
+		}
+		u := &user{name: v.Name, role: role, password: v.Password}
+		if len(v.Token) > 0 {
+			if u.token, err = hex.DecodeString(v.Token); err != nil || len(u.token) != sha256.Size {
+				return nil, fmt.Errorf("user %s: token must be hex encoded SHA-256 hash", v.Name)
+			}
+			a.tokens = append(a.tokens, u)
+		}
+		a.users[v.Name] = u
+	}
+	if len(cfg.Password) > 0 {
+		a.users[cfg.Login] = &user{name: cfg.Login, role: Admin, password: cfg.Password, plain: true}
+	}
+	return a, nil
+}
+
+// authenticate returns role of request, ok is false if credentials were given but are wrong
+func (a *Authenticator) authenticate(r *http.Request) (*user, bool) {
+	header := r.Header.Get("Authorization")
+	if strings.HasPrefix(header, "Bearer ") {
+		sum := sha256.Sum256([]byte(strings.TrimPrefix(header, "Bearer ")))
+		for _, u := range a.tokens {
+			if subtle.ConstantTimeCompare(sum[:], u.token) == 1 {
+				return u, true
+			}
+		}
+		return nil, false
+	}
+	name, pass, ok := r.BasicAuth()
+	if !ok {
+		return nil, true
+	}
+	u, ok := a.users[name]
+	if !ok || len(u.password) == 0 {
+		return nil, false
+	}
+	if u.plain {
+		return u, subtle.ConstantTimeCompare([]byte(pass), []byte(u.password)) == 1
+	}
+	return u, a.credentials.verify(u, pass)
+}
+
+// Require lets request through if it's made by user with given role or above, public routes
+// require Viewer role and are open to everyone if frontend is public
+func (a *Authenticator) Require(role Role, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, ok := a.authenticate(r)
+		if !ok {
+			deny(w, http.StatusUnauthorized)
+			return
+		}
+		granted := Anonymous
+		if u != nil {
+			granted = u.role
+		}
+		if granted < role && !(role == Viewer && a.public) {
+			if u == nil {
+				deny(w, http.StatusUnauthorized)
+			} else {
+				deny(w, http.StatusForbidden)
+			}
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, granted)))
+	})
+}
+
+func deny(w http.ResponseWriter, status int) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="stratum"`)
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+// RoleOf returns role request was authenticated with by Require
+func RoleOf(r *http.Request) Role {
+	role, _ := r.Context().Value(contextKey{}).(Role)
+	return role
+}
+
+// Redact reports whether sensitive fields like IPs and upstream URLs must be hidden from request
+func Redact(r *http.Request) bool {
+	return RoleOf(r) < Admin
+}
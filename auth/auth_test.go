@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sammy007/monero-stratum/auth/password"
+	"github.com/sammy007/monero-stratum/pool"
+)
+
+func TestRequire(t *testing.T) {
+	token := sha256.Sum256([]byte("t0ken"))
+	a, err := New(&pool.Frontend{
+		Public: true,
+		Users: []pool.User{
+			{Name: "bob", Role: "operator", Password: password.Hash("secret")},
+			{Name: "ci", Role: "admin", Token: hex.EncodeToString(token[:])},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var role Role
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role = RoleOf(r)
+	})
+
+	tests := []struct {
+		required Role
+		user     string
+		password string
+		token    string
+		status   int
+		role     Role
+	}{
+		{Viewer, "", "", "", http.StatusOK, Anonymous},
+		{Operator, "", "", "", http.StatusUnauthorized, Anonymous},
+		{Operator, "bob", "secret", "", http.StatusOK, Operator},
+		{Viewer, "bob", "wrong", "", http.StatusUnauthorized, Anonymous},
+		{Admin, "bob", "secret", "", http.StatusForbidden, Anonymous},
+		{Admin, "", "", "t0ken", http.StatusOK, Admin},
+		{Viewer, "", "", "wrong", http.StatusUnauthorized, Anonymous},
+	}
+	for i, tt := range tests {
+		role = Anonymous
+		r := httptest.NewRequest("GET", "/", nil)
+		if len(tt.user) > 0 {
+			r.SetBasicAuth(tt.user, tt.password)
+		}
+		if len(tt.token) > 0 {
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		a.Require(tt.required, h).ServeHTTP(w, r)
+		if w.Code != tt.status || role != tt.role {
+			t.Errorf("#%d: expected %d as %v, got %d as %v", i, tt.status, tt.role, w.Code, role)
+		}
+	}
+}
+
+func TestPublicWithoutUsers(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		cfg    pool.Frontend
+		status int
+	}{
+		// Empty legacy password used to mean open stats
+		{pool.Frontend{Password: ""}, http.StatusOK},
+		{pool.Frontend{Login: "admin", Password: "secret"}, http.StatusUnauthorized},
+		{pool.Frontend{Users: []pool.User{{Name: "bob", Role: "viewer", Password: password.Hash("secret")}}}, http.StatusUnauthorized},
+	}
+	for i, tt := range tests {
+		a, err := New(&tt.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		a.Require(Viewer, h).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != tt.status {
+			t.Errorf("#%d: expected %d, got %d", i, tt.status, w.Code)
+		}
+	}
+}
+
+func TestCredentialCache(t *testing.T) {
+	c := newCredentialCache()
+	checks := 0
+	c.check = func(hash, pass string) bool {
+		checks++
+		return password.Check(hash, pass)
+	}
+	bob := &user{name: "bob", password: password.Hash("secret")}
+	alice := &user{name: "alice", password: bob.password}
+	tests := []struct {
+		user   *user
+		pass   string
+		ok     bool
+		checks int
+	}{
+		{bob, "secret", true, 1},
+		// Verified credentials aren't hashed again
+		{bob, "secret", true, 1},
+		{bob, "wrong", false, 2},
+		{bob, "wrong", false, 3},
+		{alice, "secret", true, 4},
+	}
+	for i, tt := range tests {
+		if ok := c.verify(tt.user, tt.pass); ok != tt.ok || checks != tt.checks {
+			t.Errorf("#%d: expected %v after %d checks, got %v after %d", i, tt.ok, tt.checks, ok, checks)
+		}
+	}
+
+	for k, v := range c.entries {
+		v.expiresAt = time.Now().Add(-time.Second)
+		c.entries[k] = v
+	}
+	if !c.verify(bob, "secret") || checks != 5 {
+		t.Error("Expired credentials are not checked again")
+	}
+}
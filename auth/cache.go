@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"github.com/sammy007/monero-stratum/auth/password"
+)
+
+// Dashboards poll with basic auth, so verified credentials are remembered for a while
+// instead of running slow password hash on every request
+const (
+	credentialTTL    = 5 * time.Minute
+	maxCredentials   = 1024
+	concurrentChecks = 1
+)
+
+// credentialCache keeps HMAC of verified name and password under random per process key,
+// so neither passwords nor their fast hashes are held in memory
+type credentialCache struct {
+	key []byte
+	sync.Mutex
+	entries map[string]credential
+	// Slow checks run one at a time, guessing passwords can't take more than single core
+	checks chan struct{}
+	check  func(hash, password string) bool
+}
+
+type credential struct {
+	user      *user
+	expiresAt time.Time
+}
+
+func newCredentialCache() *credentialCache {
+	c := &credentialCache{
+		key:     make([]byte, sha256.Size),
+		entries: make(map[string]credential),
+		checks:  make(chan struct{}, concurrentChecks),
+		check:   password.Check,
+	}
+	rand.Read(c.key)
+	return c
+}
+
+// verify checks password of user, hashing it only if it hasn't been verified recently
+func (c *credentialCache) verify(u *user, pass string) bool {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(u.name + "\x00" + pass))
+	id := string(mac.Sum(nil))
+	now := time.Now()
+
+	c.Lock()
+	entry, ok := c.entries[id]
+	c.Unlock()
+	if ok && entry.user == u && now.Before(entry.expiresAt) {
+		return true
+	}
+
+	c.checks <- struct{}{}
+	ok = c.check(u.password, pass)
+	<-c.checks
+	if !ok {
+		return false
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if len(c.entries) >= maxCredentials {
+		for k, v := range c.entries {
+			if now.After(v.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		// Still full of live entries, start over rather than grow
+		if len(c.entries) >= maxCredentials {
+			c.entries = make(map[string]credential)
+		}
+	}
+	c.entries[id] = credential{user: u, expiresAt: now.Add(credentialTTL)}
+	return true
+}
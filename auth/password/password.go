@@ -0,0 +1,86 @@
+// Package password hashes frontend user passwords, it's shared by auth and config validation
+package password
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Password hashes are PBKDF2-HMAC-SHA256 in form pbkdf2-sha256$<iterations>$<salt>$<hash>, salt and hash are base64
+const (
+	hashScheme     = "pbkdf2-sha256"
+	hashIterations = 50000
+	saltSize       = 16
+)
+
+// Hash returns salted hash of password to be put in config
+func Hash(password string) string {
+	salt := make([]byte, saltSize)
+	rand.Read(salt)
+	key := pbkdf2([]byte(password), salt, hashIterations)
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("%s$%d$%s$%s", hashScheme, hashIterations, enc.EncodeToString(salt), enc.EncodeToString(key))
+}
+
+// Valid reports whether hash is in format produced by Hash
+func Valid(hash string) bool {
+	_, _, _, err := parseHash(hash)
+	return err == nil
+}
+
+func Check(hash, password string) bool {
+	iterations, salt, key, err := parseHash(hash)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(pbkdf2([]byte(password), salt, iterations), key) == 1
+}
+
+func parseHash(hash string) (int, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != hashScheme {
+		return 0, nil, nil, fmt.Errorf("unknown hash format")
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return 0, nil, nil, fmt.Errorf("invalid iterations")
+	}
+	enc := base64.RawStdEncoding
+	salt, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	key, err := enc.DecodeString(parts[3])
+	if err != nil || len(key) != sha256.Size {
+		return 0, nil, nil, fmt.Errorf("invalid hash")
+	}
+	return iterations, salt, key, nil
+}
+
+// pbkdf2 derives single block key, which is all SHA-256 sized key needs (RFC 8018)
+func pbkdf2(password, salt []byte, iterations int) []byte {
+	prf := hmac.New(sha256.New, password)
+	prf.Write(salt)
+	var block [4]byte
+	binary.BigEndian.PutUint32(block[:], 1)
+	prf.Write(block[:])
+	u := prf.Sum(nil)
+	key := make([]byte, len(u))
+	copy(key, u)
+	for i := 1; i < iterations; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
@@ -0,0 +1,30 @@
+package password
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestPBKDF2(t *testing.T) {
+	// RFC 7914 test vectors of PBKDF2-HMAC-SHA256
+	if key := hex.EncodeToString(pbkdf2([]byte("password"), []byte("salt"), 1)); key != "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b" {
+		t.Errorf("Invalid key: %s", key)
+	}
+	if key := hex.EncodeToString(pbkdf2([]byte("password"), []byte("salt"), 4096)); key != "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a" {
+		t.Errorf("Invalid key: %s", key)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	hash := Hash("hunter2")
+	if !Valid(hash) || !Check(hash, "hunter2") {
+		t.Errorf("Password doesn't match its hash %s", hash)
+	}
+	if Check(hash, "hunter3") || Check("hunter2", "hunter2") {
+		t.Error("Wrong password matches")
+	}
+	// Prefix alone doesn't make valid hash
+	if Valid("pbkdf2-sha256$50000$...") {
+		t.Error("Malformed hash is valid")
+	}
+}
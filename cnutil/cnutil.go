@@ -26,3 +26,14 @@ func ValidateAddress(addr string) bool {
 	result := C.validate_address(input, size)
 	return (bool)(result)
 }
+
+// DecodeAddress returns base58 prefix of address, which tells network and address type
+func DecodeAddress(addr string) (uint64, bool) {
+	input := C.CString(addr)
+	defer C.free(unsafe.Pointer(input))
+
+	var prefix C.uint64_t
+	size := (C.uint32_t)(len(addr))
+	result := C.decode_address(input, size, &prefix)
+	return uint64(prefix), (bool)(result)
+}
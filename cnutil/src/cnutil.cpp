@@ -25,3 +25,9 @@ extern "C" bool validate_address(const char *addr, size_t len) {
     uint64_t prefix;
     return tools::base58::decode_addr(addr, prefix, output);
 }
+
+extern "C" bool decode_address(const char *addr, size_t len, uint64_t *prefix) {
+    std::string input = std::string(addr, len);
+    std::string output = "";
+    return tools::base58::decode_addr(input, *prefix, output);
+}
@@ -7,6 +7,7 @@ extern "C" {
 
 uint32_t convert_blob(const char *blob, uint32_t len, char *out);
 bool validate_address(const char *addr, uint32_t len);
+bool decode_address(const char *addr, uint32_t len, uint64_t *prefix);
 
 #ifdef __cplusplus
 }
@@ -0,0 +1,150 @@
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event types
+const (
+	BlockFound     = "block.found"
+	BlockRejected  = "block.rejected"
+	UpstreamSwitch = "upstream.switch"
+	NewTemplate    = "template.new"
+	MinerLogin     = "miner.login"
+	WorkerOffline  = "alert.offline"
+	HashrateDrop   = "alert.hashrateDrop"
+	InvalidRatio   = "alert.invalidRatio"
+)
+
+type Event struct {
+	Type      string      `json:"type"`
+	Coin      string      `json:"coin"`
+	Timestamp int64       `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+type BlockFoundData struct {
+	Hash      string   `json:"hash"`
+	Height    int64    `json:"height"`
+	Address   string   `json:"address"`
+	Worker    string   `json:"worker"`
+	Ip        string   `json:"ip"`
+	Solo      bool     `json:"solo"`
+	Variance  float64  `json:"variance"`
+	Upstreams []string `json:"upstreams"`
+}
+
+type BlockRejectedData struct {
+	Hash    string `json:"hash"`
+	Height  int64  `json:"height"`
+	Address string `json:"address"`
+	Worker  string `json:"worker"`
+	Ip      string `json:"ip"`
+	Reason  string `json:"reason"`
+}
+
+type UpstreamSwitchData struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Height int64  `json:"height"`
+}
+
+type NewTemplateData struct {
+	Upstream   string `json:"upstream"`
+	Height     int64  `json:"height"`
+	Difficulty int64  `json:"difficulty"`
+	Reason     string `json:"reason"`
+}
+
+type MinerLoginData struct {
+	Address string `json:"address"`
+	Worker  string `json:"worker"`
+	Ip      string `json:"ip"`
+	Port    int    `json:"port"`
+	Agent   string `json:"agent"`
+	Solo    bool   `json:"solo"`
+}
+
+// AlertData tells which rule has fired for worker and values it was evaluated with
+type AlertData struct {
+	Address      string  `json:"address"`
+	Worker       string  `json:"worker"`
+	Message      string  `json:"message"`
+	LastBeat     int64   `json:"lastBeat"`
+	Hashrate     float64 `json:"hashrate"`
+	Hashrate24h  float64 `json:"hashrate24h"`
+	InvalidRatio float64 `json:"invalidRatio"`
+	Threshold    float64 `json:"threshold"`
+}
+
+// Bus fans out events to subscribers, publishing never blocks and events are dropped for subscribers which lag behind
+type Bus struct {
+	published int64
+	dropped   int64
+	sync.RWMutex
+	subs     []*subscription
+	webhooks []*Webhook
+}
+
+type subscription struct {
+	types map[string]bool
+	ch    chan *Event
+}
+
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe returns channel receiving events of given types, or all events if no types are given
+func (b *Bus) Subscribe(bufSize int, types ...string) <-chan *Event {
+	sub := &subscription{ch: make(chan *Event, bufSize)}
+	if len(types) > 0 {
+		sub.types = make(map[string]bool)
+		for _, t := range types {
+			sub.types[t] = true
+		}
+	}
+	b.Lock()
+	b.subs = append(b.subs, sub)
+	b.Unlock()
+	return sub.ch
+}
+
+// Publish is safe to call on nil bus, so publishers don't need to check if events are wanted
+func (b *Bus) Publish(coin, eventType string, data interface{}) {
+	if b == nil {
+		return
+	}
+	e := &Event{Type: eventType, Coin: coin, Timestamp: time.Now().UnixNano() / int64(time.Millisecond), Data: data}
+	atomic.AddInt64(&b.published, 1)
+
+	b.RLock()
+	defer b.RUnlock()
+	for _, sub := range b.subs {
+		if sub.types != nil && !sub.types[eventType] {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			atomic.AddInt64(&b.dropped, 1)
+		}
+	}
+}
+
+func (b *Bus) Stats() map[string]interface{} {
+	b.RLock()
+	defer b.RUnlock()
+	webhooks := make([]map[string]interface{}, 0, len(b.webhooks))
+	for _, w := range b.webhooks {
+		webhooks = append(webhooks, w.stats())
+	}
+	return map[string]interface{}{
+		"published":   atomic.LoadInt64(&b.published),
+		"dropped":     atomic.LoadInt64(&b.dropped),
+		"subscribers": len(b.subs),
+		"webhooks":    webhooks,
+	}
+}
@@ -0,0 +1,113 @@
+package events
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sammy007/monero-stratum/logging"
+	"github.com/sammy007/monero-stratum/pool"
+)
+
+func TestBusFilter(t *testing.T) {
+	bus := NewBus()
+	blocks := bus.Subscribe(1, BlockFound, BlockRejected)
+	all := bus.Subscribe(1)
+
+	bus.Publish("monero", MinerLogin, &MinerLoginData{Worker: "rig1"})
+	if len(blocks) != 0 {
+		t.Error("Subscriber received event of unwanted type")
+	}
+	if e := <-all; e.Type != MinerLogin || e.Coin != "monero" {
+		t.Errorf("Invalid event: %v", e)
+	}
+
+	bus.Publish("monero", BlockFound, &BlockFoundData{Height: 100})
+	bus.Publish("monero", BlockRejected, &BlockRejectedData{Height: 101})
+	if e := <-blocks; e.Data.(*BlockFoundData).Height != 100 {
+		t.Errorf("Invalid event: %v", e)
+	}
+	stats := bus.Stats()
+	if stats["published"].(int64) != 3 || stats["dropped"].(int64) != 2 {
+		t.Errorf("Lagging subscribers must not block publishing: %v", stats)
+	}
+
+	var nilBus *Bus
+	nilBus.Publish("monero", BlockFound, nil)
+}
+
+func TestWebhook(t *testing.T) {
+	logging.SetOutput(ioutil.Discard)
+	attempts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(EventHeader) != BlockFound {
+			t.Errorf("Invalid event header: %s", r.Header.Get(EventHeader))
+		}
+		if sig := r.Header.Get(SignatureHeader); sig != "sha256="+Sign([]byte("secret"), body) {
+			t.Errorf("Invalid signature: %s", sig)
+		}
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	w := NewWebhook(&pool.Webhook{Name: "test", Url: ts.URL, Secret: "secret", Retries: 2, RetryBackoff: "1ms"})
+	e := &Event{Type: BlockFound, Coin: "monero", Data: &BlockFoundData{Height: 100}}
+	if err := w.Send(e); err != nil || attempts != 3 || w.Delivered != 1 {
+		t.Errorf("Event is not delivered after retries: %v, attempts %d", err, attempts)
+	}
+
+	attempts = 0
+	w.retries = 1
+	if err := w.Send(e); err == nil || attempts != 2 || w.Failed != 1 {
+		t.Errorf("Event must fail once retries are exhausted: %v, attempts %d", err, attempts)
+	}
+
+	bus := NewBus()
+	w.Subscribe(bus)
+	webhooks := bus.Stats()["webhooks"].([]map[string]interface{})
+	if len(webhooks) != 1 || webhooks[0]["delivered"].(int64) != 1 || webhooks[0]["failed"].(int64) != 1 {
+		t.Errorf("Bus stats must count webhook deliveries: %v", webhooks)
+	}
+}
+
+func TestPublicOnly(t *testing.T) {
+	logging.SetOutput(ioutil.Discard)
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"8.8.8.8", true},
+		{"2001:4860:4860::8888", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.31.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::1", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"224.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if PublicIP(net.ParseIP(tt.ip)) != tt.public {
+			t.Errorf("%s: expected public %v", tt.ip, tt.public)
+		}
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	w := NewWebhook(&pool.Webhook{Name: "test", Url: ts.URL})
+	w.PublicOnly()
+	if err := w.Send(&Event{Type: BlockFound}); err == nil {
+		t.Error("Webhook is delivered to loopback address")
+	}
+}
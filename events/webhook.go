@@ -0,0 +1,185 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/sammy007/monero-stratum/logging"
+	"github.com/sammy007/monero-stratum/pool"
+)
+
+const (
+	EventHeader     = "X-Stratum-Event"
+	SignatureHeader = "X-Stratum-Signature"
+
+	defaultWebhookTimeout = 10 * time.Second
+	defaultRetryBackoff   = time.Second
+	defaultQueueSize      = 1024
+)
+
+// Webhook posts events as JSON, body is signed with HMAC-SHA256 of secret if it's set
+type Webhook struct {
+	Delivered    int64
+	Failed       int64
+	Name         string
+	Url          string
+	secret       []byte
+	events       []string
+	retries      int
+	retryBackoff time.Duration
+	queueSize    int
+	client       *http.Client
+	log          *logging.Logger
+}
+
+func NewWebhook(cfg *pool.Webhook) *Webhook {
+	w := &Webhook{Name: cfg.Name, Url: cfg.Url, secret: []byte(cfg.Secret), events: cfg.Events, retries: cfg.Retries}
+	timeout, _ := time.ParseDuration(cfg.Timeout)
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+	w.client = &http.Client{Timeout: timeout}
+	w.retryBackoff, _ = time.ParseDuration(cfg.RetryBackoff)
+	if w.retryBackoff <= 0 {
+		w.retryBackoff = defaultRetryBackoff
+	}
+	w.queueSize = cfg.QueueSize
+	if w.queueSize <= 0 {
+		w.queueSize = defaultQueueSize
+	}
+	w.log = logging.New("events").With(logging.Fields{"webhook": w.Name})
+	return w
+}
+
+// Networks webhooks registered through API must not reach: loopback, private, shared, link-local and unspecified
+var privateNetworks []*net.IPNet
+
+func init() {
+	for _, v := range []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12", "192.168.0.0/16",
+		"::/128", "::1/128", "fc00::/7", "fe80::/10",
+	} {
+		_, network, _ := net.ParseCIDR(v)
+		privateNetworks = append(privateNetworks, network)
+	}
+}
+
+// PublicIP reports whether ip is routable unicast address outside of private networks
+func PublicIP(ip net.IP) bool {
+	if ip == nil || ip.IsMulticast() || ip.IsLinkLocalMulticast() {
+		return false
+	}
+	for _, v := range privateNetworks {
+		if v.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// PublicOnly makes webhook refuse to connect to non-public IPs. Address is checked when connecting,
+// so neither redirects nor DNS answers changed after registration can point it inside.
+func (w *Webhook) PublicOnly() {
+	dialer := &net.Dialer{
+		Timeout: w.client.Timeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if !PublicIP(net.ParseIP(host)) {
+				return fmt.Errorf("Webhook address %s is not public", host)
+			}
+			return nil
+		},
+	}
+	// Proxy from environment would be dialed instead of webhook host, so it's not used
+	w.client.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		},
+		TLSHandshakeTimeout: w.client.Timeout,
+	}
+}
+
+// Subscribe starts delivering events of bus in background, one at a time to keep order
+func (w *Webhook) Subscribe(bus *Bus) {
+	ch := bus.Subscribe(w.queueSize, w.events...)
+	bus.Lock()
+	bus.webhooks = append(bus.webhooks, w)
+	bus.Unlock()
+	go func() {
+		for e := range ch {
+			w.Send(e)
+		}
+	}()
+}
+
+func (w *Webhook) stats() map[string]interface{} {
+	return map[string]interface{}{
+		"name":      w.Name,
+		"delivered": atomic.LoadInt64(&w.Delivered),
+		"failed":    atomic.LoadInt64(&w.Failed),
+	}
+}
+
+// Send posts event, retrying with exponential backoff on network errors and non 2xx replies
+func (w *Webhook) Send(e *Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	backoff := w.retryBackoff
+	for i := 0; ; i++ {
+		if err = w.post(e.Type, body); err == nil {
+			atomic.AddInt64(&w.Delivered, 1)
+			return nil
+		}
+		if i >= w.retries {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	atomic.AddInt64(&w.Failed, 1)
+	w.log.Errorf("Unable to deliver %s event: %v", e.Type, err)
+	return err
+}
+
+func (w *Webhook) post(eventType string, body []byte) error {
+	req, err := http.NewRequest("POST", w.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, eventType)
+	if len(w.secret) > 0 {
+		req.Header.Set(SignatureHeader, "sha256="+Sign(w.secret, body))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Webhook replied with %s", resp.Status)
+	}
+	return nil
+}
+
+// Sign returns hex encoded HMAC-SHA256 of body, receivers compare it with signature header
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -18,6 +18,13 @@ func Hash(blob []byte, fast bool, height int64) []byte {
 	return output
 }
 
+// HashVariant computes slow hash with explicit PoW variant instead of one derived from blob major version
+func HashVariant(blob []byte, variant int, height int64) []byte {
+	output := make([]byte, 32)
+	C.cryptonight_variant_hash((*C.char)(unsafe.Pointer(&blob[0])), (*C.char)(unsafe.Pointer(&output[0])), (C.uint32_t)(len(blob)), (C.int)(variant), (C.uint64_t)(uint64(height)))
+	return output
+}
+
 func FastHash(blob []byte) []byte {
 	return Hash(append([]byte{byte(len(blob))}, blob...), true, 0)
 }
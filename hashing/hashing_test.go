@@ -30,6 +30,37 @@ func TestHash_fast(t *testing.T) {
 	}
 }
 
+func TestHashVariant(t *testing.T) {
+	blob, _ := hex.DecodeString("01009091e4aa05ff5fe4801727ed0c1b8b339e1a0054d75568fec6ba9c4346e88b10d59edbf6858b2b00008a63b2865b65b84d28bb31feb057b16a21e2eda4bf6cc6377e3310af04debe4a01")
+	tests := []struct {
+		major   byte
+		variant int
+	}{
+		{1, 0},
+		{7, 1},
+		{8, 2},
+	}
+	// Explicit variant gives the same hash as variant derived from major version of blob
+	for i, tt := range tests {
+		blob[0] = tt.major
+		hash := hex.EncodeToString(HashVariant(blob, tt.variant, 0))
+		expectedHash := hex.EncodeToString(Hash(blob, false, 0))
+		if hash != expectedHash {
+			t.Errorf("#%d: invalid variant hash", i)
+		}
+	}
+
+	// Variant is not ignored
+	seen := make(map[string]int)
+	for variant := 0; variant <= 2; variant++ {
+		hash := hex.EncodeToString(HashVariant(blob, variant, 0))
+		if v, ok := seen[hash]; ok {
+			t.Errorf("Variants %d and %d give the same hash", v, variant)
+		}
+		seen[hash] = variant
+	}
+}
+
 func TestFastHash(t *testing.T) {
 	blob, _ := hex.DecodeString("01009091e4aa05ff5fe4801727ed0c1b8b339e1a0054d75568fec6ba9c4346e88b10d59edbf6858b2b00008a63b2865b65b84d28bb31feb057b16a21e2eda4bf6cc6377e3310af04debe4a01")
 	hashBytes := FastHash(blob)
@@ -5,6 +5,10 @@ void cryptonight_hash(const char* input, char* output, uint32_t len, uint64_t he
     cn_slow_hash(input, len, output, variant, 0, height);
 }
 
+void cryptonight_variant_hash(const char* input, char* output, uint32_t len, int variant, uint64_t height) {
+    cn_slow_hash(input, len, output, variant, 0, height);
+}
+
 void cryptonight_fast_hash(const char* input, char* output, uint32_t len) {
     cn_fast_hash(input, len, output);
 }
@@ -1,2 +1,3 @@
 void cryptonight_hash(const char* input, char* output, uint32_t len, uint64_t height);
+void cryptonight_variant_hash(const char* input, char* output, uint32_t len, int variant, uint64_t height);
 void cryptonight_fast_hash(const char* input, char* output, uint32_t len);
@@ -0,0 +1,258 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Level int
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+	FatalLevel
+)
+
+var levelNames = []string{"debug", "info", "warn", "error", "fatal"}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+func ParseLevel(name string) (Level, error) {
+	for i, v := range levelNames {
+		if v == strings.ToLower(name) {
+			return Level(i), nil
+		}
+	}
+	return InfoLevel, fmt.Errorf("unknown log level %q", name)
+}
+
+type Fields map[string]interface{}
+
+type Config struct {
+	Format       string
+	Level        string
+	Categories   map[string]string
+	RateLimit    int
+	RateInterval time.Duration
+}
+
+// Shared state of all loggers, loggers only carry category and fields, so they can be created before Configure
+var (
+	mu      sync.Mutex
+	out     io.Writer = os.Stderr
+	limiter *rateLimiter
+	// Levels are checked on every share, so they are swapped as a whole and read without locking
+	current atomic.Value
+)
+
+type settings struct {
+	jsonFormat bool
+	level      Level
+	levels     map[string]Level
+}
+
+func init() {
+	current.Store(&settings{level: InfoLevel})
+}
+
+// Configure sets output format, default and per category levels and rate limit of repetitive warnings and errors
+func Configure(cfg *Config) error {
+	defaultLevel := InfoLevel
+	if len(cfg.Level) > 0 {
+		l, err := ParseLevel(cfg.Level)
+		if err != nil {
+			return err
+		}
+		defaultLevel = l
+	}
+	categoryLevels := make(map[string]Level)
+	for k, v := range cfg.Categories {
+		l, err := ParseLevel(v)
+		if err != nil {
+			return fmt.Errorf("%s: %v", k, err)
+		}
+		categoryLevels[k] = l
+	}
+	if cfg.Format != "" && cfg.Format != "text" && cfg.Format != "json" {
+		return fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+
+	current.Store(&settings{jsonFormat: cfg.Format == "json", level: defaultLevel, levels: categoryLevels})
+	mu.Lock()
+	defer mu.Unlock()
+	limiter = nil
+	if cfg.RateLimit > 0 && cfg.RateInterval > 0 {
+		limiter = newRateLimiter(cfg.RateLimit, cfg.RateInterval)
+	}
+	return nil
+}
+
+// SetOutput is only meant for tests
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	out = w
+	mu.Unlock()
+}
+
+type Logger struct {
+	category string
+	fields   Fields
+}
+
+func New(category string) *Logger {
+	return &Logger{category: category}
+}
+
+// With returns logger which adds given fields to every line
+func (l *Logger) With(fields Fields) *Logger {
+	merged := make(Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &Logger{category: l.category, fields: merged}
+}
+
+func (l *Logger) Enabled(lvl Level) bool {
+	return l.enabled(current.Load().(*settings), lvl)
+}
+
+func (l *Logger) enabled(cfg *settings, lvl Level) bool {
+	if v, ok := cfg.levels[l.category]; ok {
+		return lvl >= v
+	}
+	return lvl >= cfg.level
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.log(DebugLevel, format, args...)
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.log(InfoLevel, format, args...)
+}
+
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.log(WarnLevel, format, args...)
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.log(ErrorLevel, format, args...)
+}
+
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	l.log(FatalLevel, format, args...)
+	os.Exit(1)
+}
+
+func (l *Logger) log(lvl Level, format string, args ...interface{}) {
+	cfg := current.Load().(*settings)
+	if !l.enabled(cfg, lvl) {
+		return
+	}
+
+	// Repetitive warnings and errors are told apart by category and message format
+	suppressed := int64(0)
+	if lvl >= WarnLevel && lvl < FatalLevel {
+		mu.Lock()
+		if limiter != nil {
+			var ok bool
+			if ok, suppressed = limiter.allow(l.category+"\x00"+format, time.Now()); !ok {
+				mu.Unlock()
+				return
+			}
+		}
+		mu.Unlock()
+	}
+
+	now := time.Now()
+	msg := fmt.Sprintf(format, args...)
+	var buf bytes.Buffer
+	if cfg.jsonFormat {
+		entry := make(map[string]interface{}, len(l.fields)+5)
+		for k, v := range l.fields {
+			if err, ok := v.(error); ok {
+				v = err.Error()
+			}
+			entry[k] = v
+		}
+		entry["time"] = now.Format(time.RFC3339)
+		entry["level"] = lvl.String()
+		entry["category"] = l.category
+		entry["msg"] = msg
+		if suppressed > 0 {
+			entry["suppressed"] = suppressed
+		}
+		json.NewEncoder(&buf).Encode(entry)
+	} else {
+		fmt.Fprintf(&buf, "%s %-5s [%s] %s", now.Format("2006/01/02 15:04:05"), strings.ToUpper(lvl.String()), l.category, msg)
+		keys := make([]string, 0, len(l.fields))
+		for k := range l.fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&buf, " %s=%v", k, l.fields[k])
+		}
+		if suppressed > 0 {
+			fmt.Fprintf(&buf, " suppressed=%d", suppressed)
+		}
+		buf.WriteByte('\n')
+	}
+	mu.Lock()
+	out.Write(buf.Bytes())
+	mu.Unlock()
+}
+
+// Allows up to limit lines of the same kind per interval, number of dropped lines is reported with the next one let through
+type rateLimiter struct {
+	limit    int
+	interval time.Duration
+	windows  map[string]*rateWindow
+}
+
+type rateWindow struct {
+	start      time.Time
+	count      int
+	suppressed int64
+}
+
+func newRateLimiter(limit int, interval time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, interval: interval, windows: make(map[string]*rateWindow)}
+}
+
+func (r *rateLimiter) allow(key string, now time.Time) (bool, int64) {
+	w, ok := r.windows[key]
+	if !ok || now.Sub(w.start) >= r.interval {
+		// Forget idle keys once in a while to keep map small
+		if len(r.windows) > 1024 {
+			r.windows = make(map[string]*rateWindow)
+		}
+		suppressed := int64(0)
+		if ok {
+			suppressed = w.suppressed
+		}
+		r.windows[key] = &rateWindow{start: now, count: 1}
+		return true, suppressed
+	}
+	if w.count >= r.limit {
+		w.suppressed++
+		return false, 0
+	}
+	w.count++
+	return true, 0
+}
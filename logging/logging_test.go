@@ -0,0 +1,97 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLevels(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	Configure(&Config{Level: "info", Categories: map[string]string{"shares": "warn"}})
+
+	New("shares").Infof("Valid share")
+	New("blocks").Debugf("Debug line")
+	if buf.Len() > 0 {
+		t.Errorf("Disabled levels are logged: %s", buf.String())
+	}
+	New("blocks").With(Fields{"height": 100, "ip": "127.0.0.1"}).Infof("Block %s found", "abc")
+	line := buf.String()
+	if !strings.Contains(line, "INFO  [blocks] Block abc found height=100 ip=127.0.0.1\n") {
+		t.Errorf("Invalid text line: %s", line)
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	Configure(&Config{Format: "json"})
+
+	New("stratum").With(Fields{"worker": "rig1", "port": 3333}).Warnf("Malformed request")
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["level"] != "warn" || entry["category"] != "stratum" || entry["msg"] != "Malformed request" || entry["worker"] != "rig1" || entry["port"] != float64(3333) {
+		t.Errorf("Invalid JSON line: %v", entry)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	r := newRateLimiter(2, time.Minute)
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		ok, _ := r.allow("key", now)
+		if ok != (i < 2) {
+			t.Errorf("Unexpected rate limiter decision %v for line %v", ok, i)
+		}
+	}
+	if ok, _ := r.allow("other", now); !ok {
+		t.Error("Different lines must be limited apart")
+	}
+	ok, suppressed := r.allow("key", now.Add(time.Minute))
+	if !ok || suppressed != 3 {
+		t.Errorf("Expected 3 suppressed lines reported in new window, got %v", suppressed)
+	}
+}
+
+type blockingWriter struct {
+	writing chan bool
+	release chan bool
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	w.writing <- true
+	<-w.release
+	return len(p), nil
+}
+
+func TestEnabledWhileWriting(t *testing.T) {
+	w := &blockingWriter{writing: make(chan bool), release: make(chan bool)}
+	SetOutput(w)
+	Configure(&Config{Level: "info"})
+
+	written := make(chan bool)
+	go func() {
+		New("stratum").Infof("Slow line")
+		written <- true
+	}()
+	<-w.writing
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 1000; i++ {
+			New("shares").Enabled(DebugLevel)
+		}
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Level check must not wait for output")
+	}
+	close(w.release)
+	<-written
+}
@@ -2,55 +2,124 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"fmt"
 	"math/rand"
 	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
 
+	"github.com/sammy007/monero-stratum/auth"
+	"github.com/sammy007/monero-stratum/auth/password"
+	"github.com/sammy007/monero-stratum/events"
+	"github.com/sammy007/monero-stratum/logging"
 	"github.com/sammy007/monero-stratum/pool"
 	"github.com/sammy007/monero-stratum/stratum"
 
-	"github.com/goji/httpauth"
 	"github.com/gorilla/mux"
 	"github.com/yvasiyarov/gorelic"
 )
 
 var cfg pool.Config
 
+var log = logging.New("main")
+
 func startStratum() {
 	if cfg.Threads > 0 {
 		runtime.GOMAXPROCS(cfg.Threads)
-		log.Printf("Running with %v threads", cfg.Threads)
+		log.Infof("Running with %v threads", cfg.Threads)
 	} else {
 		n := runtime.NumCPU()
 		runtime.GOMAXPROCS(n)
-		log.Printf("Running with default %v threads", n)
+		log.Infof("Running with default %v threads", n)
+	}
+
+	bus := events.NewBus()
+	for i := range cfg.Webhooks {
+		w := events.NewWebhook(&cfg.Webhooks[i])
+		w.Subscribe(bus)
+		log.Infof("Webhook %s => %s, events: %v", w.Name, w.Url, cfg.Webhooks[i].Events)
 	}
 
-	s := stratum.NewStratum(&cfg)
+	p := stratum.NewPools(poolConfigs(&cfg), bus)
 	if cfg.Frontend.Enabled {
-		go startFrontend(&cfg, s)
+		go startFrontend(&cfg, p)
 	}
-	s.Listen()
+	p.Listen()
 }
 
-func startFrontend(cfg *pool.Config, s *stratum.StratumServer) {
-	r := mux.NewRouter()
-	r.HandleFunc("/stats", s.StatsIndex)
-	r.PathPrefix("/").Handler(http.FileServer(http.Dir("./www/")))
-	var err error
-	if len(cfg.Frontend.Password) > 0 {
-		auth := httpauth.SimpleBasicAuth(cfg.Frontend.Login, cfg.Frontend.Password)
-		err = http.ListenAndServe(cfg.Frontend.Listen, auth(r))
-	} else {
-		err = http.ListenAndServe(cfg.Frontend.Listen, r)
+// poolConfigs returns configs of all hosted pools, top level config is the only pool unless pools are listed
+func poolConfigs(cfg *pool.Config) []*pool.Config {
+	if len(cfg.Pools) == 0 {
+		return []*pool.Config{cfg}
 	}
+	var cfgs []*pool.Config
+	for i := range cfg.Pools {
+		v := &cfg.Pools[i]
+		if v.Threads == 0 {
+			v.Threads = cfg.Threads
+		}
+		// Pools keep their files apart even if they are given the same paths
+		for _, path := range []*string{&v.Alerts.Path, &v.BlockSubmit.Journal, &v.ShareLog.Path} {
+			if len(*path) > 0 {
+				*path = coinPath(*path, v.Coin.Name)
+			}
+		}
+		cfgs = append(cfgs, v)
+	}
+	return cfgs
+}
+
+// coinPath inserts coin name before extension of file, like alerts.monero.json
+func coinPath(path, coin string) string {
+	if len(coin) == 0 {
+		coin = "monero"
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + coin + ext
+}
+
+func startFrontend(cfg *pool.Config, p *stratum.Pools) {
+	a, err := auth.New(&cfg.Frontend)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("%v", err)
+	}
+	if cfg.Frontend.HideIP {
+		log.Warnf("frontend.hideIP is deprecated and ignored, IPs are only shown to admins")
+	}
+	r := mux.NewRouter()
+
+	// Public group is open to everyone if frontend is public, IPs and upstream URLs are only shown to admins
+	public := func(path string, h http.HandlerFunc) *mux.Route {
+		return r.Handle(path, a.Require(auth.Viewer, h))
 	}
+	public("/pools", p.PoolsIndex)
+	public("/stats", p.StatsIndex)
+	public("/stats/{coin}", p.CoinStats)
+	public("/stats/{coin}/blocks", p.Blocks)
+	public("/stats/{coin}/upstreams", p.Upstreams)
+	public("/stats/{coin}/address/{address}", p.AddressStats)
+	// Rules are checked against operator role or token of address by handler
+	public("/alerts/{address}", p.AlertRules).Methods("GET", "POST", "DELETE")
+
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Handle("/resubmit", a.Require(auth.Operator, http.HandlerFunc(p.ResubmitBlock))).Methods("POST")
+	admin.Handle("/alerts/{address}/token", a.Require(auth.Operator, http.HandlerFunc(p.AlertToken))).Methods("GET")
+	admin.Handle("/config", a.Require(auth.Admin, http.HandlerFunc(configIndex))).Methods("GET")
+
+	r.PathPrefix("/").Handler(a.Require(auth.Viewer, http.FileServer(http.Dir("./www/"))))
+	if err = http.ListenAndServe(cfg.Frontend.Listen, r); err != nil {
+		log.Fatalf("%v", err)
+	}
+}
+
+// configIndex shows running config with secrets masked
+func configIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(cfg.Masked()))
 }
 
 func startNewrelic() {
@@ -64,28 +133,77 @@ func startNewrelic() {
 	}
 }
 
-func readConfig(cfg *pool.Config) {
-	configFileName := "config.json"
-	if len(os.Args) > 1 {
-		configFileName = os.Args[1]
-	}
+func readConfig(cfg *pool.Config, configFileName string) error {
 	configFileName, _ = filepath.Abs(configFileName)
-	log.Printf("Loading config: %v", configFileName)
+	log.Infof("Loading config: %v", configFileName)
 
 	configFile, err := os.Open(configFileName)
 	if err != nil {
-		log.Fatal("File error: ", err.Error())
+		return fmt.Errorf("File error: %v", err)
 	}
 	defer configFile.Close()
 	jsonParser := json.NewDecoder(configFile)
+	// Misspelled setting would otherwise silently fall back to zero value
+	jsonParser.DisallowUnknownFields()
 	if err = jsonParser.Decode(&cfg); err != nil {
-		log.Fatal("Config error: ", err.Error())
+		return fmt.Errorf("Config error: %v", err)
 	}
+	return nil
+}
+
+// loadConfig reads and validates config, reports every problem found and returns false if there was any
+func loadConfig(cfg *pool.Config, configFileName string) bool {
+	if err := readConfig(cfg, configFileName); err != nil {
+		log.Errorf("%v", err)
+		return false
+	}
+	if err := cfg.ApplyEnv(os.Environ()); err != nil {
+		log.Errorf("Environment error: %v", err)
+		return false
+	}
+	log.Infof("Config: %s", cfg.Masked())
+	errs := cfg.Validate()
+	for _, err := range errs {
+		log.Errorf("Config error: %v", err)
+	}
+	if len(errs) > 0 {
+		return false
+	}
+	rateInterval, _ := time.ParseDuration(cfg.Logging.RateInterval)
+	logging.Configure(&logging.Config{
+		Format:       cfg.Logging.Format,
+		Level:        cfg.Logging.Level,
+		Categories:   cfg.Logging.Categories,
+		RateLimit:    cfg.Logging.RateLimit,
+		RateInterval: rateInterval,
+	})
+	return true
 }
 
 func main() {
 	rand.Seed(time.Now().UTC().UnixNano())
-	readConfig(&cfg)
+
+	configFileName := "config.json"
+	if len(os.Args) > 2 && os.Args[1] == "hash-password" {
+		fmt.Println(password.Hash(os.Args[2]))
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "check-config" {
+		if len(os.Args) > 2 {
+			configFileName = os.Args[2]
+		}
+		if !loadConfig(&cfg, configFileName) {
+			os.Exit(1)
+		}
+		log.Infof("Config is valid")
+		return
+	}
+	if len(os.Args) > 1 {
+		configFileName = os.Args[1]
+	}
+	if !loadConfig(&cfg, configFileName) {
+		os.Exit(1)
+	}
 	startNewrelic()
 	startStratum()
 }
@@ -0,0 +1,214 @@
+package pool
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Any config field can be overridden with environment variable named after its JSON path,
+// upper cased with underscores and prefixed with STRATUM, for example:
+//
+//	frontend.password    => STRATUM_FRONTEND_PASSWORD
+//	upstream[0].host     => STRATUM_UPSTREAM_0_HOST
+//	coin.addressPrefixes => STRATUM_COIN_ADDRESS_PREFIXES=18,19,42
+//
+// Variable with _FILE suffix reads value from file instead, which is handy for mounted secrets.
+const EnvPrefix = "STRATUM"
+
+const maskedValue = "******"
+
+// ApplyEnv overrides config fields with values of given environment, list elements are appended if index is past the end
+func (c *Config) ApplyEnv(environ []string) error {
+	env := make(map[string]string)
+	for _, kv := range environ {
+		if i := strings.Index(kv, "="); i > 0 {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+	return applyEnv(reflect.ValueOf(c).Elem(), EnvPrefix, env)
+}
+
+func applyEnv(v reflect.Value, name string, env map[string]string) error {
+	if v.Kind() == reflect.Struct {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			key := jsonName(t.Field(i))
+			if len(key) == 0 {
+				continue
+			}
+			if err := applyEnv(v.Field(i), name+"_"+envName(key), env); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Struct {
+		for i := 0; ; i++ {
+			prefix := name + "_" + strconv.Itoa(i)
+			if i >= v.Len() {
+				if !hasEnvPrefix(env, prefix+"_") {
+					return nil
+				}
+				v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+			}
+			if err := applyEnv(v.Index(i), prefix, env); err != nil {
+				return err
+			}
+		}
+	}
+
+	value, ok, err := lookupEnv(env, name)
+	if err != nil || !ok {
+		return err
+	}
+	if err = setValue(v, value); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
+
+// lookupEnv prefers plain variable over _FILE one, trailing newline of file is dropped
+func lookupEnv(env map[string]string, name string) (string, bool, error) {
+	if value, ok := env[name]; ok {
+		return value, true, nil
+	}
+	path, ok := env[name+"_FILE"]
+	if !ok {
+		return "", false, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("%s_FILE: %v", name, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), true, nil
+}
+
+func hasEnvPrefix(env map[string]string, prefix string) bool {
+	for k := range env {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func setValue(v reflect.Value, value string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		// Lists of plain values are comma separated
+		items := strings.Split(value, ",")
+		if len(value) == 0 {
+			items = nil
+		}
+		list := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setValue(list.Index(i), strings.TrimSpace(item)); err != nil {
+				return err
+			}
+		}
+		v.Set(list)
+	case reflect.Map:
+		// Maps are comma separated key=value pairs
+		m := reflect.MakeMap(v.Type())
+		for _, item := range strings.Split(value, ",") {
+			kv := strings.SplitN(strings.TrimSpace(item), "=", 2)
+			if len(kv) != 2 {
+				if len(item) == 0 {
+					continue
+				}
+				return fmt.Errorf("expected key=value, got %q", item)
+			}
+			val := reflect.New(v.Type().Elem()).Elem()
+			if err := setValue(val, kv[1]); err != nil {
+				return err
+			}
+			m.SetMapIndex(reflect.ValueOf(kv[0]), val)
+		}
+		v.Set(m)
+	default:
+		return fmt.Errorf("unsupported type %v", v.Type())
+	}
+	return nil
+}
+
+func jsonName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// envName turns camelCase JSON key into CAMEL_CASE
+func envName(key string) string {
+	var b bytes.Buffer
+	runes := []rune(key)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) && !unicode.IsUpper(runes[i-1]) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// Masked returns config as JSON with values of fields tagged as secret hidden, suitable for logging
+func (c *Config) Masked() string {
+	data, _ := json.Marshal(c)
+	var masked Config
+	json.Unmarshal(data, &masked)
+	maskSecrets(reflect.ValueOf(&masked).Elem())
+	data, _ = json.Marshal(&masked)
+	return string(data)
+}
+
+func maskSecrets(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := v.Field(i)
+			if t.Field(i).Tag.Get("secret") == "true" && f.Kind() == reflect.String && f.Len() > 0 {
+				f.SetString(maskedValue)
+			} else {
+				maskSecrets(f)
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			maskSecrets(v.Index(i))
+		}
+	}
+}
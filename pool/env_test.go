@@ -0,0 +1,66 @@
+package pool
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestApplyEnv(t *testing.T) {
+	secret, err := ioutil.TempFile("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(secret.Name())
+	secret.WriteString("hunter2\n")
+	secret.Close()
+
+	cfg := validConfig()
+	err = cfg.ApplyEnv([]string{
+		"STRATUM_STRATUM_TIMEOUT=5m",
+		"STRATUM_STRATUM_LISTEN_0_DIFF=16000",
+		"STRATUM_UPSTREAM_1_HOST=10.0.0.1",
+		"STRATUM_UPSTREAM_1_PORT=18081",
+		"STRATUM_COIN_ADDRESS_PREFIXES=53, 54",
+		"STRATUM_FRONTEND_PUBLIC=true",
+		"STRATUM_FRONTEND_USERS_0_ROLE=admin",
+		"STRATUM_FRONTEND_HIDE_IP=true",
+		"STRATUM_FRONTEND_PASSWORD_FILE=" + secret.Name(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Stratum.Timeout != "5m" || cfg.Stratum.Ports[0].Difficulty != 16000 {
+		t.Error("Stratum settings are not overridden")
+	}
+	if len(cfg.Upstream) != 2 || cfg.Upstream[1].Host != "10.0.0.1" || cfg.Upstream[1].Port != 18081 {
+		t.Errorf("Upstream is not appended: %v", cfg.Upstream)
+	}
+	if len(cfg.Coin.AddressPrefixes) != 2 || cfg.Coin.AddressPrefixes[1] != 54 {
+		t.Errorf("Invalid address prefixes: %v", cfg.Coin.AddressPrefixes)
+	}
+	if !cfg.Frontend.Public || !cfg.Frontend.HideIP || cfg.Frontend.Password != "hunter2" || len(cfg.Frontend.Users) != 1 || cfg.Frontend.Users[0].Role != "admin" {
+		t.Error("Frontend settings are not overridden")
+	}
+
+	masked := cfg.Masked()
+	if strings.Contains(masked, "hunter2") || !strings.Contains(masked, maskedValue) {
+		t.Errorf("Secret is not masked: %s", masked)
+	}
+
+	if err = cfg.ApplyEnv([]string{"STRATUM_THREADS=two"}); err == nil {
+		t.Error("Invalid number is accepted")
+	}
+}
+
+func TestDeprecatedFields(t *testing.T) {
+	// Configs are decoded strictly, so removed settings must still be known
+	dec := json.NewDecoder(strings.NewReader(`{"frontend": {"enabled": true, "password": "", "hideIP": true}}`))
+	dec.DisallowUnknownFields()
+	var cfg Config
+	if err := dec.Decode(&cfg); err != nil {
+		t.Errorf("Old config is refused: %v", err)
+	}
+}
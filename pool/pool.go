@@ -1,47 +1,186 @@
 package pool
 
 type Config struct {
-	Address                 string     `json:"address"`
-	BypassAddressValidation bool       `json:"bypassAddressValidation"`
-	BypassShareValidation   bool       `json:"bypassShareValidation"`
-	Stratum                 Stratum    `json:"stratum"`
-	BlockRefreshInterval    string     `json:"blockRefreshInterval"`
-	UpstreamCheckInterval   string     `json:"upstreamCheckInterval"`
-	Upstream                []Upstream `json:"upstream"`
-	EstimationWindow        string     `json:"estimationWindow"`
-	LuckWindow              string     `json:"luckWindow"`
-	LargeLuckWindow         string     `json:"largeLuckWindow"`
-	Threads                 int        `json:"threads"`
-	Frontend                Frontend   `json:"frontend"`
-	NewrelicName            string     `json:"newrelicName"`
-	NewrelicKey             string     `json:"newrelicKey"`
-	NewrelicVerbose         bool       `json:"newrelicVerbose"`
-	NewrelicEnabled         bool       `json:"newrelicEnabled"`
+	Coin                    Coin            `json:"coin"`
+	Address                 string          `json:"address"`
+	BypassAddressValidation bool            `json:"bypassAddressValidation"`
+	BypassShareValidation   bool            `json:"bypassShareValidation"`
+	ShareValidation         ShareValidation `json:"shareValidation"`
+	SoloLoginPrefix         string          `json:"soloLoginPrefix"`
+	AgentPolicy             []AgentRule     `json:"agentPolicy"`
+	Stratum                 Stratum         `json:"stratum"`
+	BlockRefreshInterval    string          `json:"blockRefreshInterval"`
+	TemplateRefresh         TemplateRefresh `json:"templateRefresh"`
+	UpstreamCheckInterval   string          `json:"upstreamCheckInterval"`
+	UpstreamMaxHeightLag    int64           `json:"upstreamMaxHeightLag"`
+	Upstream                []Upstream      `json:"upstream"`
+	Proxy                   Proxy           `json:"proxy"`
+	BlockSubmit             BlockSubmit     `json:"blockSubmit"`
+	ShareLog                ShareLog        `json:"shareLog"`
+	Alerts                  Alerts          `json:"alerts"`
+	EstimationWindow        string          `json:"estimationWindow"`
+	LuckWindow              string          `json:"luckWindow"`
+	LargeLuckWindow         string          `json:"largeLuckWindow"`
+	Threads                 int             `json:"threads"`
+	Frontend                Frontend        `json:"frontend"`
+	Logging                 Logging         `json:"logging"`
+	Webhooks                []Webhook       `json:"webhooks"`
+	NewrelicName            string          `json:"newrelicName"`
+	NewrelicKey             string          `json:"newrelicKey" secret:"true"`
+	NewrelicVerbose         bool            `json:"newrelicVerbose"`
+	NewrelicEnabled         bool            `json:"newrelicEnabled"`
+	Pools                   []Config        `json:"pools"`
+}
+
+type Coin struct {
+	Name            string       `json:"name"`
+	AddressPrefixes []uint64     `json:"addressPrefixes"`
+	NonceOffset     int          `json:"nonceOffset"`
+	PowVariants     []PowVariant `json:"powVariants"`
+	BlockTime       string       `json:"blockTime"`
+	Decimals        int          `json:"decimals"`
+}
+
+// MaxPowVariant is CryptoNight-R, the last variant known to Monero hashing library
+const MaxPowVariant = 4
+
+type PowVariant struct {
+	Height  int64 `json:"height"`
+	Variant int   `json:"variant"`
+}
+
+type ShareValidation struct {
+	QueueSize      int     `json:"queueSize"`
+	Policy         string  `json:"policy"`
+	TrustThreshold int64   `json:"trustThreshold"`
+	CheckRatio     float64 `json:"checkRatio"`
+	BanTimeout     string  `json:"banTimeout"`
+	Verifier       string  `json:"verifier"`
+	RateLimit      int     `json:"rateLimit"`
+}
+
+// AgentRule refuses login of miners whose agent matches pattern, message tells them what to do
+type AgentRule struct {
+	Pattern string `json:"pattern"`
+	Message string `json:"message"`
 }
 
 type Stratum struct {
-	Timeout string `json:"timeout"`
-	Ports   []Port `json:"listen"`
+	Timeout              string `json:"timeout"`
+	LoginTimeout         string `json:"loginTimeout"`
+	MaxConnPerIP         int    `json:"maxConnPerIP"`
+	MaxConnPerAddress    int    `json:"maxConnPerAddress"`
+	MaxWorkersPerAddress int    `json:"maxWorkersPerAddress"`
+	PurgeOffline         string `json:"purgeOffline"`
+	PurgeInterval        string `json:"purgeInterval"`
+	Ports                []Port `json:"listen"`
 }
 
 type Port struct {
-	Difficulty int64  `json:"diff"`
-	Host       string `json:"host"`
-	Port       int    `json:"port"`
-	MaxConn    int    `json:"maxConn"`
+	Difficulty int64    `json:"diff"`
+	Host       string   `json:"host"`
+	Port       int      `json:"port"`
+	MaxConn    int      `json:"maxConn"`
+	Solo       bool     `json:"solo"`
+	Socket     string   `json:"socket"`
+	Allow      []string `json:"allow"`
+	Deny       []string `json:"deny"`
+}
+
+type TemplateRefresh struct {
+	Interval       string  `json:"interval"`
+	TxPoolGrowth   int64   `json:"txPoolGrowth"`
+	RewardIncrease float64 `json:"rewardIncrease"`
+	Overlap        string  `json:"overlap"`
 }
 
 type Upstream struct {
-	Name    string `json:"name"`
-	Host    string `json:"host"`
-	Port    int    `json:"port"`
-	Timeout string `json:"timeout"`
+	Name     string `json:"name"`
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Timeout  string `json:"timeout"`
+	Priority int    `json:"priority"`
+}
+
+type Proxy struct {
+	Enabled bool        `json:"enabled"`
+	Pools   []ProxyPool `json:"pools"`
+}
+
+type ProxyPool struct {
+	Name     string `json:"name"`
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Login    string `json:"login"`
+	Password string `json:"password" secret:"true"`
+	Timeout  string `json:"timeout"`
+	Priority int    `json:"priority"`
+}
+
+type BlockSubmit struct {
+	Broadcast    bool   `json:"broadcast"`
+	Journal      string `json:"journal"`
+	Retries      int    `json:"retries"`
+	RetryBackoff string `json:"retryBackoff"`
+}
+
+type ShareLog struct {
+	Path      string `json:"path"`
+	MaxSize   int64  `json:"maxSize"`
+	MaxAge    string `json:"maxAge"`
+	Compress  bool   `json:"compress"`
+	QueueSize int    `json:"queueSize"`
+}
+
+// Alerts holds limits of per-address alert rules and defaults of thresholds omitted on registration
+type Alerts struct {
+	Enabled      bool    `json:"enabled"`
+	Interval     string  `json:"interval"`
+	Cooldown     string  `json:"cooldown"`
+	HashrateDrop float64 `json:"hashrateDrop"`
+	InvalidRatio float64 `json:"invalidRatio"`
+	MinShares    int64   `json:"minShares"`
+	MaxRules     int     `json:"maxRules"`
+	Path         string  `json:"path"`
+	Timeout      string  `json:"timeout"`
+	Retries      int     `json:"retries"`
+	TokenSecret  string  `json:"tokenSecret" secret:"true"`
 }
 
 type Frontend struct {
 	Enabled  bool   `json:"enabled"`
 	Listen   string `json:"listen"`
+	Public   bool   `json:"public"`
+	Users    []User `json:"users"`
 	Login    string `json:"login"`
-	Password string `json:"password"`
-	HideIP   bool   `json:"hideIP"`
+	Password string `json:"password" secret:"true"`
+	// Deprecated: IPs are only shown to admins now, setting is accepted so old configs still load
+	HideIP bool `json:"hideIP"`
+}
+
+// User signs in with password using basic auth or with bearer token, only hashes of both are kept
+type User struct {
+	Name     string `json:"name"`
+	Role     string `json:"role"`
+	Password string `json:"password" secret:"true"`
+	Token    string `json:"token" secret:"true"`
+}
+
+type Webhook struct {
+	Name         string   `json:"name"`
+	Url          string   `json:"url"`
+	Secret       string   `json:"secret" secret:"true"`
+	Events       []string `json:"events"`
+	Timeout      string   `json:"timeout"`
+	Retries      int      `json:"retries"`
+	RetryBackoff string   `json:"retryBackoff"`
+	QueueSize    int      `json:"queueSize"`
+}
+
+type Logging struct {
+	Format       string            `json:"format"`
+	Level        string            `json:"level"`
+	Categories   map[string]string `json:"categories"`
+	RateLimit    int               `json:"rateLimit"`
+	RateInterval string            `json:"rateInterval"`
 }
@@ -0,0 +1,386 @@
+package pool
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sammy007/monero-stratum/auth/password"
+	"github.com/sammy007/monero-stratum/cnutil"
+	"github.com/sammy007/monero-stratum/logging"
+)
+
+// ConfigError tells which setting is wrong by its JSON path, like pools[1].stratum.listen[0].port
+type ConfigError struct {
+	Path    string
+	Message string
+}
+
+func (e *ConfigError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+type validator struct {
+	errors  []error
+	ports   map[int][]string
+	sockets map[string]bool
+}
+
+// Validate checks whole config and reports all errors found, not just the first one
+func (c *Config) Validate() []error {
+	v := &validator{ports: make(map[int][]string), sockets: make(map[string]bool)}
+	if len(c.Pools) == 0 {
+		v.pool("", c)
+	} else {
+		names := make(map[string]bool)
+		for i := range c.Pools {
+			p := &c.Pools[i]
+			path := fmt.Sprintf("pools[%d]", i)
+			v.pool(path+".", p)
+			if len(p.Pools) > 0 {
+				v.fail(path+".pools", "pools can't be nested")
+			}
+			// Process wide settings are only read from top level, so they would be silently ignored
+			v.topLevel(path+".frontend", !reflect.DeepEqual(p.Frontend, Frontend{}))
+			v.topLevel(path+".logging", !reflect.DeepEqual(p.Logging, Logging{}))
+			v.topLevel(path+".webhooks", len(p.Webhooks) > 0)
+			v.topLevel(path+".newrelicEnabled", p.NewrelicEnabled || p.NewrelicVerbose || len(p.NewrelicName) > 0 || len(p.NewrelicKey) > 0)
+			name := p.Coin.Name
+			if len(name) == 0 {
+				name = "monero"
+			}
+			if names[name] {
+				v.fail(path+".coin.name", "duplicate coin name %q", name)
+			}
+			names[name] = true
+		}
+	}
+	if c.Threads < 0 {
+		v.fail("threads", "must not be negative")
+	}
+	if c.Frontend.Enabled && len(c.Frontend.Listen) == 0 {
+		v.fail("frontend.listen", "is required")
+	}
+	v.users(c.Frontend.Users)
+	v.logging(&c.Logging)
+	for i, w := range c.Webhooks {
+		path := fmt.Sprintf("webhooks[%d]", i)
+		if !strings.HasPrefix(w.Url, "http://") && !strings.HasPrefix(w.Url, "https://") {
+			v.fail(path+".url", "must be http or https URL")
+		}
+		v.duration(path+".timeout", w.Timeout, false)
+		v.duration(path+".retryBackoff", w.RetryBackoff, false)
+		if w.Retries < 0 {
+			v.fail(path+".retries", "must not be negative")
+		}
+	}
+	return v.errors
+}
+
+func (v *validator) fail(path, format string, args ...interface{}) {
+	v.errors = append(v.errors, &ConfigError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// duration checks that value parses and is positive, empty value is only allowed if it's optional
+func (v *validator) duration(path, value string, required bool) {
+	if len(value) == 0 {
+		if required {
+			v.fail(path, "is required")
+		}
+		return
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		v.fail(path, "invalid duration %q", value)
+	} else if d < 0 || (required && d == 0) {
+		v.fail(path, "must be positive")
+	}
+}
+
+func (v *validator) topLevel(path string, set bool) {
+	if set {
+		v.fail(path, "is only allowed at top level")
+	}
+}
+
+// oneOf checks optional setting is one of allowed values, so typo doesn't silently select default
+func (v *validator) oneOf(path, value string, allowed ...string) {
+	if len(value) == 0 {
+		return
+	}
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.fail(path, "must be one of %q", allowed)
+}
+
+func (v *validator) port(path string, port int) {
+	if port < 1 || port > 65535 {
+		v.fail(path, "port %d is out of range 1-65535", port)
+	}
+}
+
+// listen checks that no other stratum port binds the same port on overlapping address
+func (v *validator) listen(path, host string, port int) {
+	if strings.ContainsAny(host, "[]") {
+		v.fail(path, "IPv6 host must be given without brackets")
+	}
+	for _, other := range v.ports[port] {
+		if wildcardHost(host) || wildcardHost(other) || host == other {
+			v.fail(path, "port %d is already used", port)
+			break
+		}
+	}
+	v.ports[port] = append(v.ports[port], host)
+}
+
+// socket checks unix socket listener, network lists don't apply to it since there is no remote IP
+func (v *validator) socket(path string, p *Port) {
+	if len(p.Host) > 0 || p.Port != 0 {
+		v.fail(path+".socket", "can't be combined with host and port")
+	}
+	if len(p.Allow) > 0 || len(p.Deny) > 0 {
+		v.fail(path+".socket", "allow and deny lists don't apply to unix socket")
+	}
+	if v.sockets[p.Socket] {
+		v.fail(path+".socket", "socket %s is already used", p.Socket)
+	}
+	v.sockets[p.Socket] = true
+}
+
+func wildcardHost(host string) bool {
+	return host == "" || host == "0.0.0.0" || host == "::"
+}
+
+func (v *validator) logging(c *Logging) {
+	if c.Format != "" && c.Format != "text" && c.Format != "json" {
+		v.fail("logging.format", "must be text or json")
+	}
+	if len(c.Level) > 0 {
+		if _, err := logging.ParseLevel(c.Level); err != nil {
+			v.fail("logging.level", "%v", err)
+		}
+	}
+	for k, level := range c.Categories {
+		if _, err := logging.ParseLevel(level); err != nil {
+			v.fail("logging.categories."+k, "%v", err)
+		}
+	}
+	if c.RateLimit < 0 {
+		v.fail("logging.rateLimit", "must not be negative")
+	}
+	v.duration("logging.rateInterval", c.RateInterval, c.RateLimit > 0)
+}
+
+// Role names and password hash scheme must match auth package
+var userRoles = map[string]bool{"viewer": true, "operator": true, "admin": true}
+
+func (v *validator) users(users []User) {
+	names := make(map[string]bool)
+	for i, u := range users {
+		path := fmt.Sprintf("frontend.users[%d]", i)
+		if len(u.Name) == 0 && len(u.Password) > 0 {
+			v.fail(path+".name", "is required for password login")
+		} else if names[u.Name] && len(u.Name) > 0 {
+			v.fail(path+".name", "duplicate user %q", u.Name)
+		}
+		names[u.Name] = true
+		if !userRoles[u.Role] {
+			v.fail(path+".role", "must be viewer, operator or admin")
+		}
+		if len(u.Password) == 0 && len(u.Token) == 0 {
+			v.fail(path, "password or token is required")
+		}
+		if len(u.Password) > 0 && !password.Valid(u.Password) {
+			v.fail(path+".password", "must be hash made by hash-password command")
+		}
+		if token, err := hex.DecodeString(u.Token); len(u.Token) > 0 && (err != nil || len(token) != 32) {
+			v.fail(path+".token", "must be hex encoded SHA-256 hash of token")
+		}
+	}
+}
+
+func (v *validator) pool(prefix string, c *Config) {
+	v.duration(prefix+"coin.blockTime", c.Coin.BlockTime, false)
+	if c.Coin.Decimals < 0 {
+		v.fail(prefix+"coin.decimals", "must not be negative")
+	}
+	for i, p := range c.Coin.PowVariants {
+		path := fmt.Sprintf("%scoin.powVariants[%d]", prefix, i)
+		if p.Variant < 0 || p.Variant > MaxPowVariant {
+			v.fail(path+".variant", "must be within 0-%d", MaxPowVariant)
+		}
+		if p.Height < 0 {
+			v.fail(path+".height", "must not be negative")
+		}
+	}
+	v.duration(prefix+"stratum.timeout", c.Stratum.Timeout, true)
+	v.duration(prefix+"stratum.loginTimeout", c.Stratum.LoginTimeout, false)
+	if c.Stratum.MaxConnPerIP < 0 {
+		v.fail(prefix+"stratum.maxConnPerIP", "must not be negative")
+	}
+	if c.Stratum.MaxConnPerAddress < 0 {
+		v.fail(prefix+"stratum.maxConnPerAddress", "must not be negative")
+	}
+	if c.Stratum.MaxWorkersPerAddress < 0 {
+		v.fail(prefix+"stratum.maxWorkersPerAddress", "must not be negative")
+	}
+	v.duration(prefix+"stratum.purgeOffline", c.Stratum.PurgeOffline, false)
+	if len(c.Stratum.PurgeOffline) > 0 {
+		v.duration(prefix+"stratum.purgeInterval", c.Stratum.PurgeInterval, true)
+		// Connected miners only beat on requests, so workers must not be purged before sessions time out
+		purge, err1 := time.ParseDuration(c.Stratum.PurgeOffline)
+		timeout, err2 := time.ParseDuration(c.Stratum.Timeout)
+		if err1 == nil && err2 == nil && purge > 0 && purge < timeout {
+			v.fail(prefix+"stratum.purgeOffline", "must not be shorter than stratum timeout")
+		}
+	}
+	v.duration(prefix+"upstreamCheckInterval", c.UpstreamCheckInterval, true)
+	v.duration(prefix+"estimationWindow", c.EstimationWindow, true)
+	v.duration(prefix+"luckWindow", c.LuckWindow, true)
+	v.duration(prefix+"largeLuckWindow", c.LargeLuckWindow, true)
+	v.duration(prefix+"templateRefresh.interval", c.TemplateRefresh.Interval, false)
+	v.duration(prefix+"templateRefresh.overlap", c.TemplateRefresh.Overlap, false)
+	v.duration(prefix+"shareValidation.banTimeout", c.ShareValidation.BanTimeout, false)
+	v.duration(prefix+"blockSubmit.retryBackoff", c.BlockSubmit.RetryBackoff, false)
+	v.duration(prefix+"shareLog.maxAge", c.ShareLog.MaxAge, false)
+	if c.ShareLog.MaxSize < 0 {
+		v.fail(prefix+"shareLog.maxSize", "must not be negative")
+	}
+	if c.Alerts.Enabled {
+		v.duration(prefix+"alerts.interval", c.Alerts.Interval, true)
+		v.duration(prefix+"alerts.cooldown", c.Alerts.Cooldown, false)
+		v.duration(prefix+"alerts.timeout", c.Alerts.Timeout, false)
+		if d := c.Alerts.HashrateDrop; d < 0 || d > 100 {
+			v.fail(prefix+"alerts.hashrateDrop", "must be within 0-100")
+		}
+		if r := c.Alerts.InvalidRatio; r < 0 || r > 1 {
+			v.fail(prefix+"alerts.invalidRatio", "must be within 0-1")
+		}
+		if c.Alerts.Retries < 0 {
+			v.fail(prefix+"alerts.retries", "must not be negative")
+		}
+	}
+
+	for i, r := range c.AgentPolicy {
+		if _, err := regexp.Compile(r.Pattern); err != nil || len(r.Pattern) == 0 {
+			v.fail(fmt.Sprintf("%sagentPolicy[%d].pattern", prefix, i), "must be valid regular expression")
+		}
+	}
+	if r := c.ShareValidation.CheckRatio; r < 0 || r > 1 {
+		v.fail(prefix+"shareValidation.checkRatio", "must be within 0-1")
+	}
+	if c.ShareValidation.QueueSize < 0 {
+		v.fail(prefix+"shareValidation.queueSize", "must not be negative")
+	}
+	if c.ShareValidation.TrustThreshold < 0 {
+		v.fail(prefix+"shareValidation.trustThreshold", "must not be negative")
+	}
+	if c.ShareValidation.RateLimit < 0 {
+		v.fail(prefix+"shareValidation.rateLimit", "must not be negative")
+	}
+	v.oneOf(prefix+"shareValidation.policy", c.ShareValidation.Policy, "reject", "shed")
+	v.oneOf(prefix+"shareValidation.verifier", c.ShareValidation.Verifier, "native", "daemon")
+	if c.UpstreamMaxHeightLag < 0 {
+		v.fail(prefix+"upstreamMaxHeightLag", "must not be negative")
+	}
+	if len(c.Stratum.Ports) == 0 {
+		v.fail(prefix+"stratum.listen", "at least one port is required")
+	}
+	for i, p := range c.Stratum.Ports {
+		path := fmt.Sprintf("%sstratum.listen[%d]", prefix, i)
+		if len(p.Socket) > 0 {
+			v.socket(path, &p)
+		} else {
+			v.port(path+".port", p.Port)
+			v.listen(path+".port", p.Host, p.Port)
+		}
+		if p.Difficulty <= 0 {
+			v.fail(path+".diff", "must be positive")
+		}
+		if p.MaxConn <= 0 {
+			v.fail(path+".maxConn", "must be positive")
+		}
+		for j, cidr := range p.Allow {
+			if _, err := ParseCIDR(cidr); err != nil {
+				v.fail(fmt.Sprintf("%s.allow[%d]", path, j), "%v", err)
+			}
+		}
+		for j, cidr := range p.Deny {
+			if _, err := ParseCIDR(cidr); err != nil {
+				v.fail(fmt.Sprintf("%s.deny[%d]", path, j), "%v", err)
+			}
+		}
+	}
+
+	if c.Proxy.Enabled {
+		// Miner addresses are checked against pool address unless coin profile lists prefixes
+		if !c.BypassAddressValidation && len(c.Address) == 0 && len(c.Coin.AddressPrefixes) == 0 {
+			v.fail(prefix+"address", "address or coin.addressPrefixes is required to validate miner addresses")
+		}
+		if len(c.Proxy.Pools) == 0 {
+			v.fail(prefix+"proxy.pools", "at least one upstream pool is required")
+		}
+		for i, p := range c.Proxy.Pools {
+			path := fmt.Sprintf("%sproxy.pools[%d]", prefix, i)
+			v.port(path+".port", p.Port)
+			v.duration(path+".timeout", p.Timeout, false)
+			if len(p.Host) == 0 {
+				v.fail(path+".host", "is required")
+			}
+		}
+		return
+	}
+
+	// Daemon builds block templates for pool address, so it's checked even if bypassAddressValidation is set
+	if !cnutil.ValidateAddress(c.Address) {
+		v.fail(prefix+"address", "invalid address %q", c.Address)
+	} else if len(c.Coin.AddressPrefixes) > 0 {
+		addrPrefix, _ := cnutil.DecodeAddress(c.Address)
+		allowed := false
+		for _, p := range c.Coin.AddressPrefixes {
+			allowed = allowed || p == addrPrefix
+		}
+		if !allowed {
+			v.fail(prefix+"address", "address prefix %d is not allowed by coin profile", addrPrefix)
+		}
+	}
+	v.duration(prefix+"blockRefreshInterval", c.BlockRefreshInterval, true)
+	if len(c.Upstream) == 0 {
+		v.fail(prefix+"upstream", "at least one upstream is required")
+	}
+	for i, u := range c.Upstream {
+		path := fmt.Sprintf("%supstream[%d]", prefix, i)
+		v.port(path+".port", u.Port)
+		v.duration(path+".timeout", u.Timeout, true)
+		if len(u.Host) == 0 {
+			v.fail(path+".host", "is required")
+		}
+	}
+}
+
+// ParseCIDR also accepts plain IP as network of this IP only
+func ParseCIDR(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP %q", s)
+		}
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q", s)
+	}
+	return network, nil
+}
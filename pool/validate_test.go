@@ -0,0 +1,195 @@
+package pool
+
+import (
+	"testing"
+
+	"github.com/sammy007/monero-stratum/auth/password"
+)
+
+func validConfig() *Config {
+	return &Config{
+		Address:               "45pyCXYn2UBVUmCFjgKr7LF8hCTeGwucWJ2xni7qrbj6GgAZBFY6tANarozZx9DaQqHyuR1AL8HJbRmqwLhUaDpKJW4hqS1",
+		BlockRefreshInterval:  "1s",
+		UpstreamCheckInterval: "5s",
+		EstimationWindow:      "15m",
+		LuckWindow:            "24h",
+		LargeLuckWindow:       "72h",
+		Stratum: Stratum{
+			Timeout: "15m",
+			Ports:   []Port{{Host: "0.0.0.0", Port: 1111, Difficulty: 8000, MaxConn: 32768}},
+		},
+		Upstream: []Upstream{{Name: "Main", Host: "127.0.0.1", Port: 18081, Timeout: "10s"}},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if errs := validConfig().Validate(); len(errs) > 0 {
+		t.Errorf("Valid config reported errors: %v", errs)
+	}
+
+	cfg := validConfig()
+	cfg.Stratum.Timeout = "15min"
+	cfg.Stratum.Ports = append(cfg.Stratum.Ports, Port{Host: "127.0.0.1", Port: 1111, MaxConn: 1, Allow: []string{"10.0.0.1", "fd00::/8"}, Deny: []string{"10.0.0.0/33"}})
+	cfg.Stratum.Ports = append(cfg.Stratum.Ports, Port{Host: "[::1]", Port: 2222, Difficulty: 8000, MaxConn: 1})
+	cfg.Stratum.Ports = append(cfg.Stratum.Ports, Port{Socket: "/tmp/stratum.sock", Port: 3333, Difficulty: 8000, MaxConn: 1})
+	cfg.Upstream = nil
+	expected := map[string]bool{
+		"stratum.timeout":           true,
+		"stratum.listen[1].port":    true,
+		"stratum.listen[1].diff":    true,
+		"stratum.listen[1].deny[0]": true,
+		"stratum.listen[2].port":    true,
+		"stratum.listen[3].socket":  true,
+		"upstream":                  true,
+	}
+	errs := cfg.Validate()
+	if len(errs) != len(expected) {
+		t.Errorf("Expected %v errors, got %v", len(expected), errs)
+	}
+	for _, err := range errs {
+		if !expected[err.(*ConfigError).Path] {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+}
+
+func TestValidateUsers(t *testing.T) {
+	cfg := validConfig()
+	cfg.Frontend.Users = []User{
+		{Name: "bob", Role: "admin", Password: password.Hash("secret")},
+		{Name: "bob", Role: "root", Password: "hunter2"},
+		{Role: "viewer", Token: "abc"},
+		{Name: "admin", Role: "admin", Password: "REPLACE-WITH-hash-password-OUTPUT"},
+	}
+	expected := map[string]bool{
+		"frontend.users[1].name":     true,
+		"frontend.users[1].role":     true,
+		"frontend.users[1].password": true,
+		"frontend.users[2].token":    true,
+		"frontend.users[3].password": true,
+	}
+	errs := cfg.Validate()
+	if len(errs) != len(expected) {
+		t.Errorf("Expected %v errors, got %v", len(expected), errs)
+	}
+	for _, err := range errs {
+		if !expected[err.(*ConfigError).Path] {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+}
+
+func TestValidatePools(t *testing.T) {
+	cfg := &Config{Pools: []Config{*validConfig(), *validConfig()}}
+	cfg.Pools[1].Stratum.Ports = []Port{{Port: 2222, Difficulty: 8000, MaxConn: 1}}
+	errs := cfg.Validate()
+	if len(errs) != 1 || errs[0].(*ConfigError).Path != "pools[1].coin.name" {
+		t.Errorf("Expected duplicate coin error, got %v", errs)
+	}
+}
+
+func TestValidatePurge(t *testing.T) {
+	cfg := validConfig()
+	cfg.Stratum.PurgeOffline = "1m"
+	cfg.Stratum.PurgeInterval = "10m"
+	if errs := cfg.Validate(); len(errs) != 1 || errs[0].(*ConfigError).Path != "stratum.purgeOffline" {
+		t.Errorf("Expected purge period error, got %v", errs)
+	}
+	cfg.Stratum.PurgeOffline = "24h"
+	cfg.Stratum.PurgeInterval = ""
+	if errs := cfg.Validate(); len(errs) != 1 || errs[0].(*ConfigError).Path != "stratum.purgeInterval" {
+		t.Errorf("Expected purge interval error, got %v", errs)
+	}
+}
+
+func TestValidateShareValidation(t *testing.T) {
+	tests := []struct {
+		change func(c *Config)
+		path   string
+	}{
+		{func(c *Config) { c.ShareValidation.Policy, c.ShareValidation.Verifier = "shed", "daemon" }, ""},
+		{func(c *Config) { c.ShareValidation.QueueSize = -1 }, "shareValidation.queueSize"},
+		{func(c *Config) { c.ShareValidation.TrustThreshold = -1 }, "shareValidation.trustThreshold"},
+		{func(c *Config) { c.ShareValidation.RateLimit = -1 }, "shareValidation.rateLimit"},
+		{func(c *Config) { c.ShareValidation.Policy = "drop" }, "shareValidation.policy"},
+		{func(c *Config) { c.ShareValidation.Verifier = "deamon" }, "shareValidation.verifier"},
+		{func(c *Config) { c.UpstreamMaxHeightLag = -1 }, "upstreamMaxHeightLag"},
+	}
+	for i, tt := range tests {
+		cfg := validConfig()
+		tt.change(cfg)
+		errs := cfg.Validate()
+		if len(tt.path) == 0 && len(errs) > 0 {
+			t.Errorf("#%d: unexpected errors %v", i, errs)
+		} else if len(tt.path) > 0 && (len(errs) != 1 || errs[0].(*ConfigError).Path != tt.path) {
+			t.Errorf("#%d: expected %s error, got %v", i, tt.path, errs)
+		}
+	}
+}
+
+func TestValidatePowVariants(t *testing.T) {
+	cfg := validConfig()
+	cfg.Coin.PowVariants = []PowVariant{{Height: 0, Variant: 1}, {Height: -1, Variant: 2}, {Height: 100, Variant: MaxPowVariant + 1}}
+	expected := map[string]bool{
+		"coin.powVariants[1].height":  true,
+		"coin.powVariants[2].variant": true,
+	}
+	errs := cfg.Validate()
+	if len(errs) != len(expected) {
+		t.Errorf("Expected %v errors, got %v", len(expected), errs)
+	}
+	for _, err := range errs {
+		if !expected[err.(*ConfigError).Path] {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+}
+
+func TestValidatePoolsTopLevel(t *testing.T) {
+	cfg := &Config{Pools: []Config{*validConfig()}}
+	cfg.Pools[0].Frontend.Enabled = true
+	cfg.Pools[0].Logging.Level = "debug"
+	cfg.Pools[0].Webhooks = []Webhook{{Url: "https://example.com/hook"}}
+	cfg.Pools[0].NewrelicEnabled = true
+	expected := map[string]bool{
+		"pools[0].frontend":        true,
+		"pools[0].logging":         true,
+		"pools[0].webhooks":        true,
+		"pools[0].newrelicEnabled": true,
+	}
+	errs := cfg.Validate()
+	if len(errs) != len(expected) {
+		t.Errorf("Expected %v errors, got %v", len(expected), errs)
+	}
+	for _, err := range errs {
+		if !expected[err.(*ConfigError).Path] {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+}
+
+func TestValidateProxyAddress(t *testing.T) {
+	tests := []struct {
+		address  string
+		prefixes []uint64
+		bypass   bool
+		ok       bool
+	}{
+		{"", nil, false, false},
+		{validConfig().Address, nil, false, true},
+		{"", []uint64{18, 19, 42}, false, true},
+		{"", nil, true, true},
+	}
+	for i, tt := range tests {
+		cfg := validConfig()
+		cfg.Upstream = nil
+		cfg.Proxy = Proxy{Enabled: true, Pools: []ProxyPool{{Host: "pool.example.com", Port: 3333}}}
+		cfg.Address, cfg.Coin.AddressPrefixes, cfg.BypassAddressValidation = tt.address, tt.prefixes, tt.bypass
+		errs := cfg.Validate()
+		if tt.ok && len(errs) > 0 {
+			t.Errorf("#%d: unexpected errors %v", i, errs)
+		} else if !tt.ok && (len(errs) != 1 || errs[0].(*ConfigError).Path != "address") {
+			t.Errorf("#%d: expected address error, got %v", i, errs)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sammy007/monero-stratum/logging"
+	"github.com/sammy007/monero-stratum/pool"
+	"github.com/sammy007/monero-stratum/util"
+)
+
+// Client is a single stratum login to upstream pool
+type Client struct {
+	sync.RWMutex
+	seq              uint64
+	Accepts          int64
+	Rejects          int64
+	LastSubmissionAt int64
+	FailsCount       int64
+	Name             string
+	Url              string
+	Priority         int
+	login            string
+	password         string
+	timeout          time.Duration
+	conn             net.Conn
+	enc              *json.Encoder
+	sessionId        string
+	pending          map[uint64]chan *jsonRpcResp
+	jobMu            sync.Mutex
+	job              atomic.Value
+	OnJob            func(*Client)
+	log              *logging.Logger
+}
+
+type Job struct {
+	Blob   string `json:"blob"`
+	JobId  string `json:"job_id"`
+	Target string `json:"target"`
+	Height int64  `json:"height"`
+}
+
+type ErrorReply struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type jsonRpcReq struct {
+	Id      uint64      `json:"id"`
+	Version string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+type jsonRpcResp struct {
+	Id     *uint64          `json:"id"`
+	Method string           `json:"method"`
+	Result *json.RawMessage `json:"result"`
+	Params *json.RawMessage `json:"params"`
+	Error  *ErrorReply      `json:"error"`
+}
+
+type loginReply struct {
+	Id     string `json:"id"`
+	Job    *Job   `json:"job"`
+	Status string `json:"status"`
+}
+
+const (
+	MaxReqSize     = 10 * 1024
+	defaultTimeout = 10 * time.Second
+)
+
+var errDisconnected = errors.New("Disconnected from pool")
+
+func NewClient(cfg *pool.ProxyPool) *Client {
+	c := &Client{Name: cfg.Name, Priority: cfg.Priority, login: cfg.Login, password: cfg.Password}
+	c.Url = net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	c.log = logging.New("upstream").With(logging.Fields{"upstream": c.Name})
+	c.timeout, _ = time.ParseDuration(cfg.Timeout)
+	if c.timeout <= 0 {
+		c.timeout = defaultTimeout
+	}
+	return c
+}
+
+// Connect dials pool and logs in, the first job is received with login reply
+func (c *Client) Connect() error {
+	conn, err := net.DialTimeout("tcp", c.Url, c.timeout)
+	if err != nil {
+		atomic.AddInt64(&c.FailsCount, 1)
+		return err
+	}
+	c.Lock()
+	c.conn = conn
+	c.enc = json.NewEncoder(conn)
+	c.pending = make(map[uint64]chan *jsonRpcResp)
+	c.sessionId = ""
+	c.Unlock()
+	c.job.Store((*Job)(nil))
+	go c.readLoop(conn)
+
+	params := map[string]string{"login": c.login, "pass": c.password, "agent": "monero-stratum"}
+	resp, err := c.call("login", params)
+	if err == nil && resp.Error != nil {
+		err = errors.New(resp.Error.Message)
+	}
+	var reply loginReply
+	if err == nil && resp.Result != nil {
+		err = json.Unmarshal(*resp.Result, &reply)
+	}
+	if err == nil && (len(reply.Id) == 0 || reply.Job == nil) {
+		err = errors.New("Malformed login reply")
+	}
+	if err != nil {
+		c.disconnect(conn, err)
+		return err
+	}
+
+	c.Lock()
+	c.sessionId = reply.Id
+	c.Unlock()
+	c.log.Infof("Logged in to pool %s", c.Url)
+	// Pool could have pushed newer job before login reply was handled
+	c.setJob(reply.Job, false)
+	return nil
+}
+
+// Check reconnects to pool if connection is lost or pings alive connection
+func (c *Client) Check() (bool, error) {
+	if !c.Alive() {
+		err := c.Connect()
+		return err == nil, err
+	}
+	// Pool may not support keepalive, any reply means connection is fine
+	_, err := c.call("keepalived", map[string]string{"id": c.SessionId()})
+	return err == nil, err
+}
+
+func (c *Client) Submit(jobId, nonce, result string) error {
+	params := map[string]string{"id": c.SessionId(), "job_id": jobId, "nonce": nonce, "result": result}
+	resp, err := c.call("submit", params)
+	if err == nil && resp.Error != nil {
+		err = errors.New(resp.Error.Message)
+	}
+	if err != nil {
+		atomic.AddInt64(&c.Rejects, 1)
+		return err
+	}
+	atomic.AddInt64(&c.Accepts, 1)
+	atomic.StoreInt64(&c.LastSubmissionAt, util.MakeTimestamp())
+	return nil
+}
+
+func (c *Client) Alive() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.conn != nil && len(c.sessionId) > 0
+}
+
+func (c *Client) SessionId() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.sessionId
+}
+
+func (c *Client) Job() *Job {
+	job, _ := c.job.Load().(*Job)
+	return job
+}
+
+func (c *Client) setJob(job *Job, replace bool) {
+	c.jobMu.Lock()
+	if !replace && c.Job() != nil {
+		c.jobMu.Unlock()
+		return
+	}
+	c.job.Store(job)
+	c.jobMu.Unlock()
+
+	if c.OnJob != nil {
+		c.OnJob(c)
+	}
+}
+
+func (c *Client) call(method string, params interface{}) (*jsonRpcResp, error) {
+	id := atomic.AddUint64(&c.seq, 1)
+	ch := make(chan *jsonRpcResp, 1)
+
+	c.Lock()
+	conn := c.conn
+	if conn == nil {
+		c.Unlock()
+		return nil, errDisconnected
+	}
+	c.pending[id] = ch
+	conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	err := c.enc.Encode(&jsonRpcReq{Id: id, Version: "2.0", Method: method, Params: params})
+	c.Unlock()
+	if err != nil {
+		c.disconnect(conn, err)
+		return nil, err
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, errDisconnected
+		}
+		return resp, nil
+	case <-time.After(c.timeout):
+		err = fmt.Errorf("Timeout on %s call", method)
+		c.disconnect(conn, err)
+		return nil, err
+	}
+}
+
+func (c *Client) readLoop(conn net.Conn) {
+	connbuff := bufio.NewReaderSize(conn, MaxReqSize)
+
+	for {
+		data, isPrefix, err := connbuff.ReadLine()
+		if isPrefix {
+			err = errors.New("Message is too long")
+		}
+		if err != nil {
+			c.disconnect(conn, err)
+			return
+		}
+		if len(data) <= 1 {
+			continue
+		}
+
+		var msg jsonRpcResp
+		if err = json.Unmarshal(data, &msg); err != nil {
+			c.log.Warnf("Malformed message from pool: %v", err)
+			continue
+		}
+		if msg.Method == "job" && msg.Params != nil {
+			var job Job
+			if err = json.Unmarshal(*msg.Params, &job); err != nil {
+				c.log.Warnf("Malformed job from pool: %v", err)
+				continue
+			}
+			c.setJob(&job, true)
+		} else if msg.Id != nil {
+			// Reply channels are buffered and closed on disconnect under the same lock
+			c.Lock()
+			if ch, ok := c.pending[*msg.Id]; ok {
+				delete(c.pending, *msg.Id)
+				ch <- &msg
+			}
+			c.Unlock()
+		}
+	}
+}
+
+func (c *Client) disconnect(conn net.Conn, reason error) {
+	c.Lock()
+	if c.conn == conn {
+		c.log.Warnf("Disconnected from pool: %v", reason)
+		atomic.AddInt64(&c.FailsCount, 1)
+		c.conn = nil
+		c.sessionId = ""
+		for _, ch := range c.pending {
+			close(ch)
+		}
+		c.pending = nil
+	}
+	c.Unlock()
+	conn.Close()
+}
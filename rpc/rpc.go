@@ -22,6 +22,8 @@ type RPCClient struct {
 	Rejects          int64
 	LastSubmissionAt int64
 	FailsCount       int64
+	Latency          int64
+	Priority         int
 	Url              *url.URL
 	login            string
 	password         string
@@ -37,6 +39,8 @@ type GetBlockTemplateReply struct {
 	Blob           string `json:"blocktemplate_blob"`
 	ReservedOffset int    `json:"reserved_offset"`
 	PrevHash       string `json:"prev_hash"`
+	ExpectedReward int64  `json:"expected_reward"`
+	SeedHash       string `json:"seed_hash"`
 }
 
 type GetInfoReply struct {
@@ -44,6 +48,8 @@ type GetInfoReply struct {
 	OutgoingConnections int64  `json:"outgoing_connections_count"`
 	Height              int64  `json:"height"`
 	TxPoolSize          int64  `json:"tx_pool_size"`
+	TargetHeight        int64  `json:"target_height"`
+	BusySyncing         bool   `json:"busy_syncing"`
 	Status              string `json:"status"`
 }
 
@@ -59,7 +65,7 @@ func NewRPCClient(cfg *pool.Upstream) (*RPCClient, error) {
 	if err != nil {
 		return nil, err
 	}
-	rpcClient := &RPCClient{Name: cfg.Name, Url: url}
+	rpcClient := &RPCClient{Name: cfg.Name, Url: url, Priority: cfg.Priority}
 	timeout, _ := time.ParseDuration(cfg.Timeout)
 	rpcClient.client = &http.Client{
 		Timeout: timeout,
@@ -93,11 +99,35 @@ func (r *RPCClient) GetInfo() (*GetInfoReply, error) {
 	return reply, err
 }
 
+// CalcPow asks daemon to compute PoW hash of block blob
+func (r *RPCClient) CalcPow(majorVersion byte, height int64, blob string, seedHash string) (string, error) {
+	params := map[string]interface{}{"major_version": majorVersion, "height": height, "block_blob": blob, "seed_hash": seedHash}
+	// Daemon may fail to hash bogus share of miner, that doesn't make it sick
+	rpcResp, _, err := r.post(r.Url.String(), "calc_pow", params)
+	var reply string
+	if err != nil {
+		return reply, err
+	}
+	if rpcResp.Result != nil {
+		err = json.Unmarshal(*rpcResp.Result, &reply)
+	}
+	return reply, err
+}
+
 func (r *RPCClient) SubmitBlock(hash string) (*JSONRpcResp, error) {
 	return r.doPost(r.Url.String(), "submitblock", []string{hash})
 }
 
 func (r *RPCClient) doPost(url, method string, params interface{}) (*JSONRpcResp, error) {
+	rpcResp, sick, err := r.post(url, method, params)
+	if sick {
+		r.markSick()
+	}
+	return rpcResp, err
+}
+
+// post makes RPC call, sick reports failure that counts against health of node
+func (r *RPCClient) post(url, method string, params interface{}) (*JSONRpcResp, bool, error) {
 	jsonReq := map[string]interface{}{"jsonrpc": "2.0", "id": 0, "method": method, "params": params}
 	data, _ := json.Marshal(jsonReq)
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(data))
@@ -107,33 +137,36 @@ func (r *RPCClient) doPost(url, method string, params interface{}) (*JSONRpcResp
 	req.SetBasicAuth(r.login, r.password)
 	resp, err := r.client.Do(req)
 	if err != nil {
-		r.markSick()
-		return nil, err
+		return nil, true, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
-		return nil, errors.New(resp.Status)
+		return nil, false, errors.New(resp.Status)
 	}
 
 	var rpcResp *JSONRpcResp
 	err = json.NewDecoder(resp.Body).Decode(&rpcResp)
 	if err != nil {
-		r.markSick()
-		return nil, err
+		return nil, true, err
 	}
 	if rpcResp.Error != nil {
-		r.markSick()
-		return nil, errors.New(rpcResp.Error["message"].(string))
+		return nil, true, errors.New(rpcResp.Error["message"].(string))
 	}
-	return rpcResp, err
+	return rpcResp, false, err
 }
 
 func (r *RPCClient) Check(reserveSize int, address string) (bool, error) {
+	start := time.Now()
 	_, err := r.GetBlockTemplate(reserveSize, address)
 	if err != nil {
 		return false, err
 	}
+	atomic.StoreInt64(&r.Latency, int64(time.Since(start)/time.Millisecond))
+	_, err = r.UpdateInfo()
+	if err != nil {
+		return false, err
+	}
 	r.markAlive()
 	return !r.Sick(), nil
 }
@@ -180,3 +213,16 @@ func (r *RPCClient) Info() *GetInfoReply {
 	reply, _ := r.info.Load().(*GetInfoReply)
 	return reply
 }
+
+// Height returns last known chain height of upstream or 0 if unknown
+func (r *RPCClient) Height() int64 {
+	if info := r.Info(); info != nil {
+		return info.Height
+	}
+	return 0
+}
+
+// Synced reports whether daemon is done with blockchain sync and ready to serve templates
+func (i *GetInfoReply) Synced() bool {
+	return i.Status == "OK" && !i.BusySyncing && i.TargetHeight <= i.Height
+}
@@ -0,0 +1,139 @@
+package stratum
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sammy007/monero-stratum/pool"
+)
+
+// Reasons connection was refused for, reported in stats
+const (
+	refusedDenied     = "denied"
+	refusedBanned     = "banned"
+	refusedMaxConn    = "maxConn"
+	refusedPerIP      = "maxConnPerIP"
+	refusedPerAddress = "maxConnPerAddress"
+	refusedLogin      = "loginTimeout"
+)
+
+// admission caps connections per IP and logged in sessions per address across all ports of pool
+type admission struct {
+	maxPerIP      int
+	maxPerAddress int
+	sync.Mutex
+	perIP      map[string]int
+	perAddress map[string]int
+	refused    map[string]*int64
+}
+
+func newAdmission(cfg *pool.Stratum) *admission {
+	a := &admission{
+		maxPerIP:      cfg.MaxConnPerIP,
+		maxPerAddress: cfg.MaxConnPerAddress,
+		perIP:         make(map[string]int),
+		perAddress:    make(map[string]int),
+		refused:       make(map[string]*int64),
+	}
+	for _, reason := range []string{refusedDenied, refusedBanned, refusedMaxConn, refusedPerIP, refusedPerAddress, refusedLogin} {
+		a.refused[reason] = new(int64)
+	}
+	return a
+}
+
+func (a *admission) refuse(reason string) {
+	atomic.AddInt64(a.refused[reason], 1)
+}
+
+func (a *admission) acquireIP(ip string) bool {
+	a.Lock()
+	defer a.Unlock()
+	if a.maxPerIP > 0 && a.perIP[ip] >= a.maxPerIP {
+		return false
+	}
+	a.perIP[ip]++
+	return true
+}
+
+func (a *admission) releaseIP(ip string) {
+	a.Lock()
+	defer a.Unlock()
+	if a.perIP[ip] <= 1 {
+		delete(a.perIP, ip)
+	} else {
+		a.perIP[ip]--
+	}
+}
+
+func (a *admission) acquireAddress(address string) bool {
+	a.Lock()
+	defer a.Unlock()
+	if a.maxPerAddress > 0 && a.perAddress[address] >= a.maxPerAddress {
+		return false
+	}
+	a.perAddress[address]++
+	return true
+}
+
+func (a *admission) releaseAddress(address string) {
+	if len(address) == 0 {
+		return
+	}
+	a.Lock()
+	defer a.Unlock()
+	if a.perAddress[address] <= 1 {
+		delete(a.perAddress, address)
+	} else {
+		a.perAddress[address]--
+	}
+}
+
+func (a *admission) stats() map[string]interface{} {
+	refused := make(map[string]int64, len(a.refused))
+	for k, v := range a.refused {
+		refused[k] = atomic.LoadInt64(v)
+	}
+	a.Lock()
+	defer a.Unlock()
+	active := 0
+	for _, n := range a.perIP {
+		active += n
+	}
+	return map[string]interface{}{
+		"active":    active,
+		"ips":       len(a.perIP),
+		"addresses": len(a.perAddress),
+		"refused":   refused,
+	}
+}
+
+// networks is allow or deny list of port
+type networks []*net.IPNet
+
+func parseNetworks(cidrs []string) networks {
+	var n networks
+	for _, v := range cidrs {
+		// Config is validated on start
+		network, _ := pool.ParseCIDR(v)
+		n = append(n, network)
+	}
+	return n
+}
+
+func (n networks) contains(ip net.IP) bool {
+	for _, v := range n {
+		if v.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// permitted checks IP against deny list first, then against allow list if it's not empty
+func (e *Endpoint) permitted(ip net.IP) bool {
+	if e.deny.contains(ip) {
+		return false
+	}
+	return len(e.allow) == 0 || e.allow.contains(ip)
+}
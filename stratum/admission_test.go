@@ -0,0 +1,116 @@
+package stratum
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sammy007/monero-stratum/pool"
+	"github.com/sammy007/monero-stratum/util"
+)
+
+func TestAdmit(t *testing.T) {
+	s := newTestServer(&pool.Config{Stratum: pool.Stratum{MaxConnPerIP: 2}})
+	s.banTimeout = time.Hour
+	s.banIP("10.0.0.9")
+	e := &Endpoint{config: &pool.Port{MaxConn: 4}, deny: parseNetworks([]string{"10.1.0.0/16"})}
+
+	tests := []struct {
+		ip     string
+		reason string
+	}{
+		{"10.0.0.1", ""},
+		{"10.0.0.1", ""},
+		// Per IP cap applies to connections of all ports
+		{"10.0.0.1", refusedPerIP},
+		{"10.1.0.1", refusedDenied},
+		{"10.0.0.9", refusedBanned},
+		{"10.0.0.2", ""},
+		{"10.0.0.3", ""},
+		{"10.0.0.4", refusedMaxConn},
+	}
+	for i, tt := range tests {
+		if reason, ok := s.admit(e, net.ParseIP(tt.ip), tt.ip); reason != tt.reason || ok != (len(tt.reason) == 0) {
+			t.Errorf("#%d: expected %q, got %q", i, tt.reason, reason)
+		}
+	}
+	if e.conns != 4 || s.admission.perIP["10.0.0.1"] != 2 {
+		t.Errorf("Wrong connection counts %v %v", e.conns, s.admission.perIP)
+	}
+	// Slot of closed connection is free again
+	s.admission.releaseIP("10.0.0.1")
+	e.conns--
+	if _, ok := s.admit(e, net.ParseIP("10.0.0.1"), "10.0.0.1"); !ok {
+		t.Error("Released slot is not reused")
+	}
+}
+
+func TestLoginTimeout(t *testing.T) {
+	tests := []struct {
+		address   string
+		keepalive bool
+		refused   int64
+	}{
+		{"", false, 1},
+		// Requests before login neither extend login time nor get served
+		{"", true, 1},
+		// Logged in miner gets regular timeout, it's not counted as login refusal
+		{"A", false, 0},
+	}
+	for i, tt := range tests {
+		s := newTestServer(&pool.Config{})
+		s.loginTimeout, s.timeout = 20*time.Millisecond, 50*time.Millisecond
+		server, client := net.Pipe()
+		defer client.Close()
+		conn := &countingConn{Conn: server}
+		e := &Endpoint{config: &pool.Port{}}
+		cs := &Session{conn: conn, enc: json.NewEncoder(conn), endpoint: e, address: tt.address, connectedAt: util.MakeTimestamp()}
+
+		replies := make(chan string, 1)
+		if tt.keepalive {
+			go func() {
+				r := bufio.NewReader(client)
+				for {
+					if _, err := client.Write([]byte(`{"id":1,"method":"keepalived","params":{}}` + "\n")); err != nil {
+						return
+					}
+					var reply struct {
+						Error *ErrorReply `json:"error"`
+					}
+					line, err := r.ReadBytes('\n')
+					if err != nil || json.Unmarshal(line, &reply) != nil {
+						return
+					}
+					if reply.Error != nil {
+						select {
+						case replies <- reply.Error.Message:
+						default:
+						}
+					}
+					time.Sleep(5 * time.Millisecond)
+				}
+			}()
+		}
+
+		done := make(chan struct{})
+		go func() {
+			s.handleClient(cs, e)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("#%d: session is not timed out", i)
+		}
+		if refused := *s.admission.refused[refusedLogin]; refused != tt.refused {
+			t.Errorf("#%d: expected %v login refusals, got %v", i, tt.refused, refused)
+		}
+		if tt.keepalive {
+			if message := <-replies; message != "Unauthenticated" {
+				t.Errorf("#%d: keepalive before login got %q", i, message)
+			}
+		}
+	}
+}
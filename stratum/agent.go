@@ -0,0 +1,108 @@
+package stratum
+
+import (
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sammy007/monero-stratum/pool"
+)
+
+// Agents are only used as stats keys, so overlong ones are cut
+const maxAgentLen = 128
+
+const defaultAgentMessage = "Your mining software is not supported, please upgrade"
+
+type agentRule struct {
+	pattern *regexp.Regexp
+	message string
+}
+
+type agentPolicy struct {
+	refused int64
+	rules   []agentRule
+}
+
+func newAgentPolicy(cfg []pool.AgentRule) *agentPolicy {
+	p := &agentPolicy{}
+	for _, v := range cfg {
+		rule := agentRule{pattern: regexp.MustCompile(v.Pattern), message: v.Message}
+		if len(rule.message) == 0 {
+			rule.message = defaultAgentMessage
+		}
+		p.rules = append(p.rules, rule)
+	}
+	return p
+}
+
+// check returns message of the first rule agent matches, miner is refused then
+func (p *agentPolicy) check(agent string) (string, bool) {
+	for _, r := range p.rules {
+		if r.pattern.MatchString(agent) {
+			atomic.AddInt64(&p.refused, 1)
+			return r.message, false
+		}
+	}
+	return "", true
+}
+
+func normalizeAgent(agent string) string {
+	agent = strings.TrimSpace(agent)
+	if len(agent) > maxAgentLen {
+		agent = agent[:maxAgentLen]
+	}
+	return agent
+}
+
+// parseAgent splits agent like "XMRig/6.21.0 (Linux x86_64) libuv/1.44.2" into software name and version
+func parseAgent(agent string) (string, string) {
+	if len(agent) == 0 {
+		return "unknown", ""
+	}
+	product := strings.Fields(agent)[0]
+	parts := strings.SplitN(product, "/", 3)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// agentStats counts logged in sessions by mining software and its version
+func (s *StratumServer) agentStats() map[string]interface{} {
+	clients := make(map[string]map[string]int)
+	s.sessionsMu.RLock()
+	for cs := range s.sessions {
+		name, version := parseAgent(cs.getAgent())
+		if clients[name] == nil {
+			clients[name] = make(map[string]int)
+		}
+		clients[name][version]++
+	}
+	s.sessionsMu.RUnlock()
+	return map[string]interface{}{
+		"clients": clients,
+		"refused": atomic.LoadInt64(&s.agentPolicy.refused),
+	}
+}
+
+// Session lock is held while writing to miner, so agent is kept apart to not stall stats
+func (cs *Session) setAgent(agent string) {
+	cs.agent.Store(agent)
+}
+
+func (cs *Session) getAgent() string {
+	agent, _ := cs.agent.Load().(string)
+	return agent
+}
+
+func (m *Miner) setAgent(agent string) {
+	m.Lock()
+	m.agent = agent
+	m.Unlock()
+}
+
+func (m *Miner) getAgent() string {
+	m.RLock()
+	defer m.RUnlock()
+	return m.agent
+}
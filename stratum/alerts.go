@@ -0,0 +1,458 @@
+package stratum
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sammy007/monero-stratum/auth"
+	"github.com/sammy007/monero-stratum/events"
+	"github.com/sammy007/monero-stratum/logging"
+	"github.com/sammy007/monero-stratum/pool"
+	"github.com/sammy007/monero-stratum/util"
+)
+
+const (
+	maxAlertRulesPerAddress = 5
+	alertDeliveryWorkers    = 4
+	// Miners prove they may manage rules of address with token issued by operator
+	alertTokenHeader = "X-Alert-Token"
+)
+
+// alertRule is webhook registered by miner for its address, zero threshold disables its rule
+type alertRule struct {
+	Address      string  `json:"address"`
+	Url          string  `json:"url"`
+	Secret       string  `json:"secret,omitempty"`
+	Offline      bool    `json:"offline"`
+	HashrateDrop float64 `json:"hashrateDrop"`
+	InvalidRatio float64 `json:"invalidRatio"`
+	CreatedAt    int64   `json:"createdAt"`
+	webhook      *events.Webhook
+}
+
+// Thresholds omitted on registration are taken from config
+type alertRequest struct {
+	Url          string   `json:"url"`
+	Secret       string   `json:"secret"`
+	Offline      *bool    `json:"offline"`
+	HashrateDrop *float64 `json:"hashrateDrop"`
+	InvalidRatio *float64 `json:"invalidRatio"`
+}
+
+type alertDelivery struct {
+	webhook *events.Webhook
+	event   *events.Event
+}
+
+// alertEngine evaluates rules of every address periodically, alert fires once condition
+// turns true and not again for the same worker until cooldown has passed
+type alertEngine struct {
+	fired      int64
+	delivered  int64
+	failed     int64
+	dropped    int64
+	cfg        *pool.Alerts
+	coin       string
+	cooldown   int64
+	deliveries chan *alertDelivery
+	saveMu     sync.Mutex
+	sync.RWMutex
+	rules  map[string][]*alertRule
+	count  int
+	active map[string]bool
+	last   map[string]int64
+	log    *logging.Logger
+}
+
+func newAlertEngine(cfg *pool.Alerts, coin string, l *logging.Logger) *alertEngine {
+	a := &alertEngine{
+		cfg:        cfg,
+		coin:       coin,
+		deliveries: make(chan *alertDelivery, 1024),
+		rules:      make(map[string][]*alertRule),
+		active:     make(map[string]bool),
+		last:       make(map[string]int64),
+		log:        l,
+	}
+	cooldown, _ := time.ParseDuration(cfg.Cooldown)
+	a.cooldown = int64(cooldown / time.Millisecond)
+	if err := a.load(); err != nil {
+		l.Errorf("Unable to load alert rules: %v", err)
+	}
+	for i := 0; i < alertDeliveryWorkers; i++ {
+		go a.deliver()
+	}
+	l.Infof("Alert rules enabled, %v rules loaded", a.count)
+	return a
+}
+
+// Alert URLs come from miners, so they are never delivered to pool's own networks
+func (a *alertEngine) newWebhook(r *alertRule) *events.Webhook {
+	w := events.NewWebhook(&pool.Webhook{
+		Name:    r.Address,
+		Url:     r.Url,
+		Secret:  r.Secret,
+		Timeout: a.cfg.Timeout,
+		Retries: a.cfg.Retries,
+	})
+	w.PublicOnly()
+	return w
+}
+
+// list returns copy of rules of address, add replaces rules in place
+func (a *alertEngine) list(address string) []*alertRule {
+	a.RLock()
+	defer a.RUnlock()
+	rules := make([]*alertRule, len(a.rules[address]))
+	copy(rules, a.rules[address])
+	return rules
+}
+
+// token is HMAC of coin and address, so it can be issued again at any time without keeping it
+func (a *alertEngine) token(address string) string {
+	mac := hmac.New(sha256.New, []byte(a.cfg.TokenSecret))
+	mac.Write([]byte(a.coin + "\x00" + address))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (a *alertEngine) validToken(address, token string) bool {
+	if len(a.cfg.TokenSecret) == 0 || len(token) == 0 {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(a.token(address)), []byte(token)) == 1
+}
+
+// publicHost checks every address host resolves to, delivery checks address it connects to again
+func publicHost(host string) bool {
+	ips, err := net.LookupIP(host)
+	if err != nil || len(ips) == 0 {
+		return false
+	}
+	for _, ip := range ips {
+		if !events.PublicIP(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// add registers rule or replaces rule of address with the same URL
+func (a *alertEngine) add(r *alertRule) error {
+	r.webhook = a.newWebhook(r)
+	a.Lock()
+	rules := a.rules[r.Address]
+	replaced := false
+	for i, v := range rules {
+		if v.Url == r.Url {
+			rules[i], replaced = r, true
+		}
+	}
+	if !replaced {
+		if len(rules) >= maxAlertRulesPerAddress {
+			a.Unlock()
+			return fmt.Errorf("At most %d alert URLs are allowed per address", maxAlertRulesPerAddress)
+		}
+		if a.cfg.MaxRules > 0 && a.count >= a.cfg.MaxRules {
+			a.Unlock()
+			return fmt.Errorf("Alert rules limit reached")
+		}
+		a.rules[r.Address] = append(rules, r)
+		a.count++
+	}
+	a.Unlock()
+	a.save()
+	return nil
+}
+
+// remove deletes rule of address with given URL or all its rules if URL is empty
+func (a *alertEngine) remove(address, rawurl string) int {
+	a.Lock()
+	var kept []*alertRule
+	for _, v := range a.rules[address] {
+		if len(rawurl) > 0 && v.Url != rawurl {
+			kept = append(kept, v)
+		}
+	}
+	removed := len(a.rules[address]) - len(kept)
+	if len(kept) > 0 {
+		a.rules[address] = kept
+	} else {
+		delete(a.rules, address)
+	}
+	a.count -= removed
+	a.Unlock()
+	if removed > 0 {
+		a.save()
+	}
+	return removed
+}
+
+// fire reports whether alert of given key must be sent now
+func (a *alertEngine) fire(key string, cond bool, now int64) bool {
+	a.Lock()
+	defer a.Unlock()
+	if !cond {
+		delete(a.active, key)
+		return false
+	}
+	if a.active[key] {
+		return false
+	}
+	// Condition is checked again on next evaluation once cooldown has passed
+	if now-a.last[key] < a.cooldown {
+		return false
+	}
+	a.active[key] = true
+	a.last[key] = now
+	return true
+}
+
+// prune forgets cooldowns which have already passed
+func (a *alertEngine) prune(now int64) {
+	a.Lock()
+	defer a.Unlock()
+	for k, v := range a.last {
+		if !a.active[k] && now-v >= a.cooldown {
+			delete(a.last, k)
+		}
+	}
+}
+
+// send queues alert delivery, alerts are dropped if webhooks can't keep up
+func (a *alertEngine) send(r *alertRule, e *events.Event) {
+	atomic.AddInt64(&a.fired, 1)
+	select {
+	case a.deliveries <- &alertDelivery{webhook: r.webhook, event: e}:
+	default:
+		atomic.AddInt64(&a.dropped, 1)
+	}
+}
+
+func (a *alertEngine) deliver() {
+	for d := range a.deliveries {
+		if err := d.webhook.Send(d.event); err != nil {
+			atomic.AddInt64(&a.failed, 1)
+		} else {
+			atomic.AddInt64(&a.delivered, 1)
+		}
+	}
+}
+
+func (a *alertEngine) load() error {
+	if len(a.cfg.Path) == 0 {
+		return nil
+	}
+	data, err := ioutil.ReadFile(a.cfg.Path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var rules []*alertRule
+	if err = json.Unmarshal(data, &rules); err != nil {
+		return err
+	}
+	for _, r := range rules {
+		r.webhook = a.newWebhook(r)
+		a.rules[r.Address] = append(a.rules[r.Address], r)
+		a.count++
+	}
+	return nil
+}
+
+// save rewrites rules file at once, so it's never left half written
+func (a *alertEngine) save() {
+	if len(a.cfg.Path) == 0 {
+		return
+	}
+	a.saveMu.Lock()
+	defer a.saveMu.Unlock()
+	a.RLock()
+	rules := make([]*alertRule, 0, a.count)
+	for _, v := range a.rules {
+		rules = append(rules, v...)
+	}
+	data, _ := json.MarshalIndent(rules, "", "  ")
+	a.RUnlock()
+
+	tmp := a.cfg.Path + ".tmp"
+	err := ioutil.WriteFile(tmp, data, 0600)
+	if err == nil {
+		err = os.Rename(tmp, a.cfg.Path)
+	}
+	if err != nil {
+		a.log.Errorf("Unable to save alert rules: %v", err)
+	}
+}
+
+func (a *alertEngine) stats() map[string]interface{} {
+	a.RLock()
+	defer a.RUnlock()
+	return map[string]interface{}{
+		"addresses": len(a.rules),
+		"rules":     a.count,
+		"fired":     atomic.LoadInt64(&a.fired),
+		"delivered": atomic.LoadInt64(&a.delivered),
+		"failed":    atomic.LoadInt64(&a.failed),
+		"dropped":   atomic.LoadInt64(&a.dropped),
+	}
+}
+
+// checkAlerts evaluates rules against every worker of addresses having them
+func (s *StratumServer) checkAlerts() {
+	now := util.MakeTimestamp()
+	timeout := int64(s.timeout / time.Millisecond)
+
+	for m := range s.miners.Iter() {
+		rules := s.alerts.list(m.Val.address)
+		if len(rules) == 0 {
+			continue
+		}
+		lastBeat := m.Val.getLastBeat()
+		worker := m.Val.id
+		offline := now-lastBeat > timeout
+		hashrate := m.Val.hashrate(s.estimationWindow)
+		hashrate24h := m.Val.hashrate(24 * time.Hour)
+		valid, invalid := atomic.LoadInt64(&m.Val.validShares), atomic.LoadInt64(&m.Val.invalidShares)
+		invalidRatio := float64(0)
+		if valid+invalid > 0 {
+			invalidRatio = float64(invalid) / float64(valid+invalid)
+		}
+
+		for _, r := range rules {
+			data := events.AlertData{
+				Address: r.Address, Worker: worker, LastBeat: lastBeat,
+				Hashrate: hashrate, Hashrate24h: hashrate24h, InvalidRatio: invalidRatio,
+			}
+			key := r.Url + "\x00" + m.Key + "\x00"
+
+			if r.Offline && s.alerts.fire(key+events.WorkerOffline, offline, now) {
+				data.Message = fmt.Sprintf("Worker %s is offline", worker)
+				s.sendAlert(r, events.WorkerOffline, data)
+			}
+			dropped := !offline && r.HashrateDrop > 0 && hashrate < hashrate24h*(1-r.HashrateDrop/100)
+			if r.HashrateDrop > 0 && s.alerts.fire(key+events.HashrateDrop, dropped, now) {
+				data.Message = fmt.Sprintf("Hashrate of worker %s dropped by more than %v%% against 24h average", worker, r.HashrateDrop)
+				data.Threshold = r.HashrateDrop
+				s.sendAlert(r, events.HashrateDrop, data)
+			}
+			tooMany := r.InvalidRatio > 0 && valid+invalid >= s.config.Alerts.MinShares && invalidRatio > r.InvalidRatio
+			if r.InvalidRatio > 0 && s.alerts.fire(key+events.InvalidRatio, tooMany, now) {
+				data.Message = fmt.Sprintf("Invalid share ratio of worker %s is over %v", worker, r.InvalidRatio)
+				data.Threshold = r.InvalidRatio
+				s.sendAlert(r, events.InvalidRatio, data)
+			}
+		}
+	}
+	s.alerts.prune(now)
+}
+
+func (s *StratumServer) sendAlert(r *alertRule, eventType string, data events.AlertData) {
+	s.alerts.log.With(logging.Fields{"address": r.Address, "worker": data.Worker}).Infof("%s", data.Message)
+	s.alerts.send(r, &events.Event{Type: eventType, Coin: s.coin.name, Timestamp: util.MakeTimestamp(), Data: &data})
+}
+
+// AlertRules lists (GET), registers (POST) or removes (DELETE) alert webhooks of address.
+// Operators manage rules of any address, miners need token of their address.
+func (s *StratumServer) AlertRules(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	address := mux.Vars(r)["address"]
+
+	reply := func(status int, v interface{}) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	}
+	if s.alerts == nil {
+		reply(http.StatusNotFound, map[string]interface{}{"error": "Alerts are disabled"})
+		return
+	}
+	if !s.config.BypassAddressValidation && !s.coin.validAddress(address) {
+		reply(http.StatusBadRequest, map[string]interface{}{"error": "Invalid address"})
+		return
+	}
+	if auth.RoleOf(r) < auth.Operator && !s.alerts.validToken(address, r.Header.Get(alertTokenHeader)) {
+		reply(http.StatusForbidden, map[string]interface{}{"error": "Token of address is required"})
+		return
+	}
+
+	switch r.Method {
+	case "POST":
+		var req alertRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+			reply(http.StatusBadRequest, map[string]interface{}{"error": "Malformed request"})
+			return
+		}
+		u, err := url.Parse(req.Url)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			reply(http.StatusBadRequest, map[string]interface{}{"error": "Invalid webhook URL"})
+			return
+		}
+		if !publicHost(u.Hostname()) {
+			reply(http.StatusBadRequest, map[string]interface{}{"error": "Webhook host must resolve to public addresses"})
+			return
+		}
+		rule := &alertRule{
+			Address: address, Url: req.Url, Secret: req.Secret, Offline: true,
+			HashrateDrop: s.config.Alerts.HashrateDrop, InvalidRatio: s.config.Alerts.InvalidRatio,
+			CreatedAt: util.MakeTimestamp(),
+		}
+		if req.Offline != nil {
+			rule.Offline = *req.Offline
+		}
+		if req.HashrateDrop != nil {
+			rule.HashrateDrop = *req.HashrateDrop
+		}
+		if req.InvalidRatio != nil {
+			rule.InvalidRatio = *req.InvalidRatio
+		}
+		if rule.HashrateDrop < 0 || rule.HashrateDrop > 100 || rule.InvalidRatio < 0 || rule.InvalidRatio > 1 {
+			reply(http.StatusBadRequest, map[string]interface{}{"error": "Thresholds are out of range"})
+			return
+		}
+		if err := s.alerts.add(rule); err != nil {
+			reply(http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+			return
+		}
+		s.alerts.log.With(logging.Fields{"address": address}).Infof("Alert webhook registered: %s", rule.Url)
+	case "DELETE":
+		removed := s.alerts.remove(address, r.URL.Query().Get("url"))
+		reply(http.StatusOK, map[string]interface{}{"removed": removed})
+		return
+	}
+
+	// Secrets are never shown back
+	var rules []alertRule
+	for _, v := range s.alerts.list(address) {
+		rule := *v
+		rule.Secret = ""
+		rules = append(rules, rule)
+	}
+	reply(http.StatusOK, map[string]interface{}{"address": address, "rules": rules})
+}
+
+// AlertToken issues token miner needs to manage alert rules of its address
+func (s *StratumServer) AlertToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	address := mux.Vars(r)["address"]
+	if s.alerts == nil || len(s.config.Alerts.TokenSecret) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": "Alert tokens are disabled"})
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"address": address, "token": s.alerts.token(address)})
+}
@@ -0,0 +1,77 @@
+package stratum
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sammy007/monero-stratum/auth"
+	"github.com/sammy007/monero-stratum/auth/password"
+	"github.com/sammy007/monero-stratum/logging"
+	"github.com/sammy007/monero-stratum/pool"
+)
+
+func TestAlertRules(t *testing.T) {
+	cfg := &pool.Config{BypassAddressValidation: true, Alerts: pool.Alerts{Enabled: true, TokenSecret: "secret", MaxRules: 10}}
+	s := newTestServer(cfg)
+	s.alerts = newAlertEngine(&cfg.Alerts, "monero", logging.New("alerts"))
+	a, err := auth.New(&pool.Frontend{Public: true, Users: []pool.User{{Name: "op", Role: "operator", Password: password.Hash("secret")}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	router.Handle("/alerts/{address}", a.Require(auth.Viewer, http.HandlerFunc(s.AlertRules)))
+	token := s.alerts.token("A")
+
+	tests := []struct {
+		method   string
+		address  string
+		body     string
+		token    string
+		operator bool
+		status   int
+	}{
+		{"GET", "A", "", "", false, http.StatusForbidden},
+		{"POST", "A", `{"url": "https://8.8.8.8/hook"}`, "wrong", false, http.StatusForbidden},
+		// Token of one address doesn't let manage rules of another
+		{"POST", "B", `{"url": "https://8.8.8.8/hook"}`, token, false, http.StatusForbidden},
+		{"POST", "A", `{"url": "https://8.8.8.8/hook"}`, token, false, http.StatusOK},
+		{"POST", "A", `{"url": "http://127.0.0.1/hook"}`, token, false, http.StatusBadRequest},
+		{"POST", "A", `{"url": "http://169.254.169.254/latest"}`, token, false, http.StatusBadRequest},
+		{"POST", "A", `{"url": "http://[::1]:8080/"}`, token, false, http.StatusBadRequest},
+		{"POST", "B", `{"url": "https://8.8.4.4/hook"}`, "", true, http.StatusOK},
+		{"GET", "A", "", token, false, http.StatusOK},
+		{"DELETE", "B", "", "", true, http.StatusOK},
+	}
+	for i, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/alerts/"+tt.address, strings.NewReader(tt.body))
+		if len(tt.token) > 0 {
+			r.Header.Set(alertTokenHeader, tt.token)
+		}
+		if tt.operator {
+			r.SetBasicAuth("op", "secret")
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("#%d: expected %d, got %d: %s", i, tt.status, w.Code, w.Body.String())
+		}
+	}
+	if len(s.alerts.list("A")) != 1 || len(s.alerts.list("B")) != 0 {
+		t.Errorf("Wrong rules %v %v", s.alerts.list("A"), s.alerts.list("B"))
+	}
+}
+
+func TestAlertRulesList(t *testing.T) {
+	a := newAlertEngine(&pool.Alerts{}, "monero", logging.New("alerts"))
+	a.add(&alertRule{Address: "A", Url: "https://example.com/1"})
+	rules := a.list("A")
+	rules[0] = nil
+	a.add(&alertRule{Address: "A", Url: "https://example.com/1", Offline: true})
+	if rules[0] != nil || !a.list("A")[0].Offline {
+		t.Error("List shares rules with engine")
+	}
+}
@@ -1,11 +1,17 @@
 package stratum
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"net/http"
 	"sync/atomic"
 	"time"
 
+	"github.com/gorilla/mux"
+
+	"github.com/sammy007/monero-stratum/auth"
+	"github.com/sammy007/monero-stratum/logging"
+	"github.com/sammy007/monero-stratum/proxy"
 	"github.com/sammy007/monero-stratum/rpc"
 	"github.com/sammy007/monero-stratum/util"
 )
@@ -13,8 +19,12 @@ import (
 func (s *StratumServer) StatsIndex(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(s.stats(auth.Redact(r)))
+}
 
-	hashrate, hashrate24h, totalOnline, miners := s.collectMinersStats()
+// stats hides miner IPs and upstream URLs if redact is set
+func (s *StratumServer) stats(redact bool) map[string]interface{} {
+	hashrate, hashrate24h, totalOnline, miners := s.collectMinersStats(redact)
 	stats := map[string]interface{}{
 		"miners":      miners,
 		"hashrate":    hashrate,
@@ -25,17 +35,30 @@ func (s *StratumServer) StatsIndex(w http.ResponseWriter, r *http.Request) {
 		"now":         util.MakeTimestamp(),
 	}
 
-	var upstreams []interface{}
-	current := atomic.LoadInt32(&s.upstream)
-
-	for i, u := range s.upstreams {
-		upstream := convertUpstream(u)
-		upstream["current"] = current == int32(i)
-		upstreams = append(upstreams, upstream)
+	if s.config.Proxy.Enabled {
+		stats["current"] = convertProxy(s.proxyClient(), redact)
+	} else {
+		stats["current"] = convertUpstream(s.rpc(), redact)
 	}
-	stats["upstreams"] = upstreams
-	stats["current"] = convertUpstream(s.rpc())
+	stats["upstreams"] = s.getUpstreamsStats(redact)
+	stats["coin"] = s.coin.name
 	stats["luck"] = s.getLuckStats()
+	stats["validation"] = s.validator.stats()
+	if s.verifier != nil {
+		stats["verifier"] = s.verifier.stats()
+	}
+	if s.shareAudit != nil {
+		stats["shareLog"] = s.shareAudit.stats()
+	}
+	if s.alerts != nil {
+		stats["alerts"] = s.alerts.stats()
+	}
+	if s.bus != nil {
+		stats["events"] = s.bus.Stats()
+	}
+	stats["agents"] = s.agentStats()
+	stats["connections"] = s.admission.stats()
+	stats["workers"] = s.workerStats()
 	stats["blocks"] = s.getBlocksStats()
 
 	if t := s.currentBlockTemplate(); t != nil {
@@ -43,60 +66,216 @@ func (s *StratumServer) StatsIndex(w http.ResponseWriter, r *http.Request) {
 		stats["diff"] = t.diffInt64
 		roundShares := atomic.LoadInt64(&s.roundShares)
 		stats["variance"] = float64(roundShares) / float64(t.diffInt64)
-		stats["prevHash"] = t.prevHash[0:8]
+		if len(t.prevHash) >= 8 {
+			stats["prevHash"] = t.prevHash[0:8]
+		}
+		stats["template"] = true
+		// Proxy jobs carry difficulty of upstream pool, not of network
+		if !t.proxy {
+			poolHashrate := float64(0)
+			for _, v := range miners {
+				if m := v.(map[string]interface{}); !m["solo"].(bool) {
+					poolHashrate += m["hashrate"].(float64)
+				}
+			}
+			stats["network"] = s.networkStats(t, poolHashrate)
+		}
+	}
+	return stats
+}
+
+// BlocksIndex serves blocks page: recent blocks with their status and luck
+func (s *StratumServer) BlocksIndex(w http.ResponseWriter, r *http.Request) {
+	stats := map[string]interface{}{
+		"blocks": s.getBlocksStats(),
+		"luck":   s.getLuckStats(),
+		"now":    util.MakeTimestamp(),
+	}
+	if t := s.currentBlockTemplate(); t != nil {
+		stats["height"] = t.height
+		stats["diff"] = t.diffInt64
+		stats["variance"] = float64(atomic.LoadInt64(&s.roundShares)) / float64(t.diffInt64)
 		stats["template"] = true
 	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(stats)
+}
+
+// UpstreamsIndex serves upstreams page
+func (s *StratumServer) UpstreamsIndex(w http.ResponseWriter, r *http.Request) {
+	stats := map[string]interface{}{
+		"upstreams": s.getUpstreamsStats(auth.Redact(r)),
+		"proxy":     s.config.Proxy.Enabled,
+		"now":       util.MakeTimestamp(),
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(stats)
 }
 
-func convertUpstream(u *rpc.RPCClient) map[string]interface{} {
+// AddressStats serves address lookup page: workers of address with their share stats and hashrate charts
+func (s *StratumServer) AddressStats(w http.ResponseWriter, r *http.Request) {
+	address := mux.Vars(r)["address"]
+	now := util.MakeTimestamp()
+	var workers []interface{}
+	var hashrate, hashrate24h float64
+	var validShares, staleShares, invalidShares int64
+	totalOnline := 0
+	chart := make([]hashratePoint, chartWindow/chartStep)
+
+	for m := range s.miners.Iter() {
+		if m.Val.address != address {
+			continue
+		}
+		stats := s.minerStats(m.Val.id, m.Val, now, auth.Redact(r))
+		series := m.Val.hashrateSeries(chartWindow, chartStep)
+		for i, p := range series {
+			chart[i].Timestamp = p.Timestamp
+			chart[i].Hashrate += p.Hashrate
+		}
+		stats["chart"] = series
+		hashrate += stats["hashrate"].(float64)
+		hashrate24h += stats["hashrate24h"].(float64)
+		validShares += stats["validShares"].(int64)
+		staleShares += stats["staleShares"].(int64)
+		invalidShares += stats["invalidShares"].(int64)
+		if stats["timeout"] == nil {
+			totalOnline++
+		}
+		workers = append(workers, stats)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if len(workers) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": "No workers of this address"})
+		return
+	}
+	stats := map[string]interface{}{
+		"address":       address,
+		"workers":       workers,
+		"hashrate":      hashrate,
+		"hashrate24h":   hashrate24h,
+		"totalWorkers":  len(workers),
+		"totalOnline":   totalOnline,
+		"validShares":   validShares,
+		"staleShares":   staleShares,
+		"invalidShares": invalidShares,
+		"chart":         chart,
+		"now":           now,
+	}
+	if t := s.currentBlockTemplate(); t != nil && !t.proxy {
+		stats["estimate"] = map[string]interface{}{
+			"daily":       dailyEarnings(t, hashrate),
+			"blockReward": t.expectedReward,
+			"decimals":    s.coin.decimals,
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(stats)
+}
+
+// ResubmitBlock submits journaled block blob with given hash to upstreams again
+func (s *StratumServer) ResubmitBlock(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	hash := r.URL.Query().Get("hash")
+
+	if s.journal == nil || s.config.Proxy.Enabled {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": "Block journal is disabled"})
+		return
+	}
+	entry, err := s.journal.find(hash)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": err.Error()})
+		return
+	}
+	if entry == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": "Block not found"})
+		return
+	}
+
+	blob, _ := hex.DecodeString(entry.Blob)
+	s.blockLog.With(logging.Fields{"height": entry.Height}).Infof("Manual resubmission of block %s", hash)
+	accepted, err := s.submitBlock(blob, entry.Height)
+	reply := map[string]interface{}{"hash": hash, "height": entry.Height, "upstreams": accepted}
+	status := "accepted"
+	if err != nil {
+		reply["error"] = err.Error()
+		status = "rejected"
+	}
+	s.journal.append(&journalEntry{Hash: hash, Timestamp: util.MakeTimestamp(), Height: entry.Height, Status: status, Upstreams: accepted})
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(reply)
+}
+
+func (s *StratumServer) getUpstreamsStats(redact bool) []interface{} {
+	var upstreams []interface{}
+	current := atomic.LoadInt32(&s.upstream)
+	if s.config.Proxy.Enabled {
+		for i, c := range s.proxies {
+			upstream := convertProxy(c, redact)
+			upstream["current"] = current == int32(i)
+			upstreams = append(upstreams, upstream)
+		}
+	} else {
+		for i, u := range s.upstreams {
+			upstream := convertUpstream(u, redact)
+			upstream["current"] = current == int32(i)
+			upstreams = append(upstreams, upstream)
+		}
+	}
+	return upstreams
+}
+
+func convertUpstream(u *rpc.RPCClient, redact bool) map[string]interface{} {
 	upstream := map[string]interface{}{
 		"name":             u.Name,
-		"url":              u.Url.String(),
 		"sick":             u.Sick(),
 		"accepts":          atomic.LoadInt64(&u.Accepts),
 		"rejects":          atomic.LoadInt64(&u.Rejects),
 		"lastSubmissionAt": atomic.LoadInt64(&u.LastSubmissionAt),
 		"failsCount":       atomic.LoadInt64(&u.FailsCount),
+		"latency":          atomic.LoadInt64(&u.Latency),
+		"priority":         u.Priority,
 		"info":             u.Info(),
 	}
+	if !redact {
+		upstream["url"] = u.Url.String()
+	}
 	return upstream
 }
 
-func (s *StratumServer) collectMinersStats() (float64, float64, int, []interface{}) {
+func convertProxy(c *proxy.Client, redact bool) map[string]interface{} {
+	upstream := map[string]interface{}{
+		"name":             c.Name,
+		"sick":             !c.Alive(),
+		"accepts":          atomic.LoadInt64(&c.Accepts),
+		"rejects":          atomic.LoadInt64(&c.Rejects),
+		"lastSubmissionAt": atomic.LoadInt64(&c.LastSubmissionAt),
+		"failsCount":       atomic.LoadInt64(&c.FailsCount),
+	}
+	if !redact {
+		upstream["url"] = c.Url
+	}
+	return upstream
+}
+
+func (s *StratumServer) collectMinersStats(redact bool) (float64, float64, int, []interface{}) {
 	now := util.MakeTimestamp()
 	var result []interface{}
 	totalhashrate := float64(0)
 	totalhashrate24h := float64(0)
 	totalOnline := 0
-	window24h := 24 * time.Hour
 
 	for m := range s.miners.Iter() {
-		stats := make(map[string]interface{})
-		lastBeat := m.Val.getLastBeat()
-		hashrate := m.Val.hashrate(s.estimationWindow)
-		hashrate24h := m.Val.hashrate(window24h)
-		totalhashrate += hashrate
-		totalhashrate24h += hashrate24h
-		stats["name"] = m.Key
-		stats["hashrate"] = hashrate
-		stats["hashrate24h"] = hashrate24h
-		stats["lastBeat"] = lastBeat
-		stats["validShares"] = atomic.LoadInt64(&m.Val.validShares)
-		stats["staleShares"] = atomic.LoadInt64(&m.Val.staleShares)
-		stats["invalidShares"] = atomic.LoadInt64(&m.Val.invalidShares)
-		stats["accepts"] = atomic.LoadInt64(&m.Val.accepts)
-		stats["rejects"] = atomic.LoadInt64(&m.Val.rejects)
-		if !s.config.Frontend.HideIP {
-			stats["ip"] = m.Val.ip
-		}
-
-		if now-lastBeat > (int64(s.timeout/2) / 1000000) {
-			stats["warning"] = true
-		}
-		if now-lastBeat > (int64(s.timeout) / 1000000) {
-			stats["timeout"] = true
-		} else {
+		stats := s.minerStats(m.Val.id, m.Val, now, redact)
+		totalhashrate += stats["hashrate"].(float64)
+		totalhashrate24h += stats["hashrate24h"].(float64)
+		if stats["timeout"] == nil {
 			totalOnline++
 		}
 		result = append(result, stats)
@@ -104,6 +283,35 @@ func (s *StratumServer) collectMinersStats() (float64, float64, int, []interface
 	return totalhashrate, totalhashrate24h, totalOnline, result
 }
 
+func (s *StratumServer) minerStats(id string, m *Miner, now int64, redact bool) map[string]interface{} {
+	stats := make(map[string]interface{})
+	lastBeat := m.getLastBeat()
+	stats["name"] = id
+	stats["hashrate"] = m.hashrate(s.estimationWindow)
+	stats["hashrate24h"] = m.hashrate(24 * time.Hour)
+	stats["lastBeat"] = lastBeat
+	stats["validShares"] = atomic.LoadInt64(&m.validShares)
+	stats["staleShares"] = atomic.LoadInt64(&m.staleShares)
+	stats["invalidShares"] = atomic.LoadInt64(&m.invalidShares)
+	stats["accepts"] = atomic.LoadInt64(&m.accepts)
+	stats["rejects"] = atomic.LoadInt64(&m.rejects)
+	stats["solo"] = m.solo
+	stats["agent"] = m.getAgent()
+	stats["trust"] = atomic.LoadInt64(&m.trust)
+	stats["sessions"] = m.sessionStats(redact)
+	if !redact {
+		stats["ip"] = m.ip
+	}
+
+	if now-lastBeat > (int64(s.timeout/2) / 1000000) {
+		stats["warning"] = true
+	}
+	if now-lastBeat > (int64(s.timeout) / 1000000) {
+		stats["timeout"] = true
+	}
+	return stats
+}
+
 func (s *StratumServer) getLuckStats() map[string]interface{} {
 	now := util.MakeTimestamp()
 	var variance float64
@@ -115,6 +323,13 @@ func (s *StratumServer) getLuckStats() map[string]interface{} {
 	defer s.blocksMu.Unlock()
 
 	for k, v := range s.blockStats {
+		// Solo and rejected blocks don't take part in pool luck
+		if len(v.address) > 0 || v.rejected {
+			if k < now-int64(s.luckLargeWindow) {
+				delete(s.blockStats, k)
+			}
+			continue
+		}
 		if k >= now-int64(s.luckWindow) {
 			blocksCount++
 			variance += v.variance
@@ -156,6 +371,13 @@ func (s *StratumServer) getBlocksStats() []interface{} {
 				"hash":      v.hash,
 				"variance":  v.variance,
 				"timestamp": k,
+				"upstreams": v.upstreams,
+				"solo":      len(v.address) > 0,
+				"address":   v.address,
+				"status":    "accepted",
+			}
+			if v.rejected {
+				block["status"] = "rejected"
 			}
 			result = append(result, block)
 		} else {
@@ -0,0 +1,225 @@
+package stratum
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"math/big"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/sammy007/monero-stratum/logging"
+	"github.com/sammy007/monero-stratum/pool"
+)
+
+// Append-only JSONL audit log of every share decision, written in background to keep submit path fast
+type shareAudit struct {
+	dropped  int64
+	written  int64
+	path     string
+	maxSize  int64
+	maxAge   time.Duration
+	compress bool
+	queue    chan *shareRecord
+	file     *os.File
+	buf      *bufio.Writer
+	size     int64
+	openedAt time.Time
+	log      *logging.Logger
+}
+
+type shareRecord struct {
+	Timestamp   int64  `json:"timestamp"`
+	Address     string `json:"address"`
+	Worker      string `json:"worker"`
+	Ip          string `json:"ip"`
+	Port        int    `json:"port"`
+	JobId       string `json:"jobId"`
+	Height      int64  `json:"height"`
+	ClaimedDiff int64  `json:"claimedDiff"`
+	ActualDiff  int64  `json:"actualDiff"`
+	Verified    bool   `json:"verified"`
+	Outcome     string `json:"outcome"`
+	Reason      string `json:"reason,omitempty"`
+	block       bool
+}
+
+const (
+	shareValid   = "valid"
+	shareBlock   = "block"
+	shareInvalid = "invalid"
+)
+
+func newShareAudit(cfg *pool.ShareLog, l *logging.Logger) *shareAudit {
+	if len(cfg.Path) == 0 {
+		return nil
+	}
+	a := &shareAudit{path: cfg.Path, maxSize: cfg.MaxSize * 1024 * 1024, compress: cfg.Compress, log: l}
+	a.maxAge, _ = time.ParseDuration(cfg.MaxAge)
+	queueSize := cfg.QueueSize
+	if queueSize <= 0 {
+		queueSize = 8192
+	}
+	a.queue = make(chan *shareRecord, queueSize)
+	go a.run()
+	l.Infof("Writing share audit log to %s", cfg.Path)
+	return a
+}
+
+// write queues record, it's dropped rather than stalling miners if writer can't keep up
+func (a *shareAudit) write(r *shareRecord) {
+	if a == nil || r == nil {
+		return
+	}
+	select {
+	case a.queue <- r:
+	default:
+		atomic.AddInt64(&a.dropped, 1)
+	}
+}
+
+func (a *shareAudit) run() {
+	flush := time.NewTicker(time.Second)
+	for {
+		select {
+		case r := <-a.queue:
+			a.append(r)
+		case <-flush.C:
+			if a.buf != nil {
+				a.buf.Flush()
+			}
+			// Age rotation shouldn't wait for the next share
+			if a.expired() {
+				a.rotate()
+			}
+		}
+	}
+}
+
+func (a *shareAudit) append(r *shareRecord) {
+	data, _ := json.Marshal(r)
+	data = append(data, '\n')
+	if a.file != nil && a.maxSize > 0 && a.size+int64(len(data)) > a.maxSize {
+		a.rotate()
+	}
+	if a.file == nil && !a.open() {
+		atomic.AddInt64(&a.dropped, 1)
+		return
+	}
+	n, err := a.buf.Write(data)
+	a.size += int64(n)
+	if err != nil {
+		a.log.Errorf("Unable to write share log: %v", err)
+		return
+	}
+	atomic.AddInt64(&a.written, 1)
+}
+
+func (a *shareAudit) open() bool {
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		a.log.Errorf("Unable to open share log: %v", err)
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		a.log.Errorf("Unable to open share log: %v", err)
+		return false
+	}
+	a.file = f
+	a.buf = bufio.NewWriter(f)
+	a.size = info.Size()
+	// File left by previous run keeps aging from its first record, not from restart
+	a.openedAt = info.ModTime()
+	if started, ok := firstRecordTime(a.path); ok && a.size > 0 {
+		a.openedAt = started
+	}
+	return true
+}
+
+func firstRecordTime(path string) (time.Time, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer f.Close()
+	var r shareRecord
+	if err := json.NewDecoder(f).Decode(&r); err != nil || r.Timestamp <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, r.Timestamp*int64(time.Millisecond)), true
+}
+
+func (a *shareAudit) expired() bool {
+	return a.file != nil && a.maxAge > 0 && time.Since(a.openedAt) >= a.maxAge
+}
+
+// rotate renames current file with timestamp suffix, next record opens a fresh one
+func (a *shareAudit) rotate() {
+	a.buf.Flush()
+	a.file.Close()
+	a.file, a.buf = nil, nil
+
+	rotated := a.path + "." + time.Now().Format("20060102-150405.000")
+	if err := os.Rename(a.path, rotated); err != nil {
+		a.log.Errorf("Unable to rotate share log: %v", err)
+		return
+	}
+	a.log.Infof("Rotated share log to %s", rotated)
+	if a.compress {
+		go a.gzip(rotated)
+	}
+}
+
+func (a *shareAudit) gzip(path string) {
+	err := gzipFile(path)
+	if err != nil {
+		a.log.Errorf("Unable to compress share log %s: %v", path, err)
+		return
+	}
+	os.Remove(path)
+}
+
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err = io.Copy(gz, in); err == nil {
+		err = gz.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+	}
+	return err
+}
+
+func (a *shareAudit) stats() map[string]interface{} {
+	return map[string]interface{}{
+		"written": atomic.LoadInt64(&a.written),
+		"dropped": atomic.LoadInt64(&a.dropped),
+		"queued":  len(a.queue),
+	}
+}
+
+// setActual records difficulty of hash share was checked with, it's the claimed one if hash wasn't verified
+func (r *shareRecord) setActual(diff *big.Int, verified, block bool) {
+	if r == nil {
+		return
+	}
+	r.ActualDiff = diff.Int64()
+	r.Verified = verified
+	r.block = block
+}
@@ -0,0 +1,55 @@
+package stratum
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sammy007/monero-stratum/logging"
+)
+
+func TestShareAuditAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logging.SetOutput(ioutil.Discard)
+
+	now := time.Now()
+	hoursAgo := now.Add(-2 * time.Hour)
+	record := func(at time.Time) string {
+		return fmt.Sprintf(`{"timestamp":%d}`+"\n", at.UnixNano()/int64(time.Millisecond))
+	}
+	tests := []struct {
+		data     string
+		modified time.Time
+		expired  bool
+	}{
+		// Restart doesn't reset age of file left by previous run, even if it was written just now
+		{record(hoursAgo) + record(now), now, true},
+		{record(hoursAgo), hoursAgo, true},
+		{record(now), hoursAgo, false},
+		// Without readable record file is aged from its last write
+		{"{}\n", hoursAgo, true},
+		{"", now, false},
+	}
+	for i, tt := range tests {
+		path := filepath.Join(dir, fmt.Sprintf("%d.jsonl", i))
+		if len(tt.data) > 0 {
+			ioutil.WriteFile(path, []byte(tt.data), 0600)
+			os.Chtimes(path, tt.modified, tt.modified)
+		}
+		a := &shareAudit{path: path, maxAge: time.Hour, log: logging.New("shares")}
+		if !a.open() {
+			t.Fatalf("#%d: unable to open", i)
+		}
+		if a.expired() != tt.expired {
+			t.Errorf("#%d: expected expired %v", i, tt.expired)
+		}
+		a.file.Close()
+	}
+}
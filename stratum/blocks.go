@@ -4,19 +4,35 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/hex"
-	"log"
+	"fmt"
 	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/sammy007/monero-stratum/cnutil"
+	"github.com/sammy007/monero-stratum/events"
+	"github.com/sammy007/monero-stratum/logging"
+	"github.com/sammy007/monero-stratum/rpc"
+	"github.com/sammy007/monero-stratum/util"
 )
 
 type BlockTemplate struct {
+	replacedAt     int64
+	createdAt      int64
 	diffInt64      int64
+	expectedReward int64
+	txPoolSize     int64
 	height         int64
 	difficulty     *big.Int
 	reservedOffset int
 	prevHash       string
+	seedHash       string
+	upstream       string
 	buffer         []byte
+	coin           *coinProfile
+	proxy          bool
+	proxyJobId     string
 }
 
 func (b *BlockTemplate) nextBlob(extraNonce uint32, instanceId []byte) string {
@@ -35,29 +51,239 @@ func (s *StratumServer) fetchBlockTemplate() bool {
 	r := s.rpc()
 	reply, err := r.GetBlockTemplate(8, s.config.Address)
 	if err != nil {
-		log.Printf("Error while refreshing block template: %s", err)
+		s.upstreamLog.With(logging.Fields{"upstream": r.Name}).Errorf("Error while refreshing block template: %s", err)
 		return false
 	}
 	t := s.currentBlockTemplate()
+	l := s.upstreamLog.With(logging.Fields{"upstream": r.Name, "height": reply.Height})
 
+	reason := "new block"
 	if t != nil && t.prevHash == reply.PrevHash {
 		// Fallback to height comparison
 		if len(reply.PrevHash) == 0 && reply.Height > t.height {
-			log.Printf("New block to mine on %s at height %v, diff: %v", r.Name, reply.Height, reply.Difficulty)
+			l.Infof("New block to mine, diff: %v", reply.Difficulty)
+		} else if reason = s.templateRefreshReason(t, r, reply); len(reason) > 0 {
+			l.Infof("Refreshing block template: %s", reason)
 		} else {
 			return false
 		}
 	} else {
-		log.Printf("New block to mine on %s at height %v, diff: %v, prev_hash: %s", r.Name, reply.Height, reply.Difficulty, reply.PrevHash)
+		l.Infof("New block to mine, diff: %v, prev_hash: %s", reply.Difficulty, reply.PrevHash)
 	}
-	newTemplate := BlockTemplate{
+	newTemplate := newBlockTemplate(r, reply, s.coin)
+	s.blockTemplate.Store(newTemplate)
+	if t != nil {
+		atomic.StoreInt64(&t.replacedAt, newTemplate.createdAt)
+		if newTemplate.height != t.height {
+			s.pruneSoloTemplates(newTemplate.height)
+		}
+	}
+	s.bus.Publish(s.coin.name, events.NewTemplate, &events.NewTemplateData{Upstream: r.Name, Height: reply.Height, Difficulty: reply.Difficulty, Reason: reason})
+	return true
+}
+
+func newBlockTemplate(r *rpc.RPCClient, reply *rpc.GetBlockTemplateReply, coin *coinProfile) *BlockTemplate {
+	t := &BlockTemplate{
+		createdAt:      util.MakeTimestamp(),
 		diffInt64:      reply.Difficulty,
+		expectedReward: reply.ExpectedReward,
 		difficulty:     big.NewInt(reply.Difficulty),
 		height:         reply.Height,
 		prevHash:       reply.PrevHash,
+		seedHash:       reply.SeedHash,
+		upstream:       r.Name,
 		reservedOffset: reply.ReservedOffset,
+		coin:           coin,
 	}
-	newTemplate.buffer, _ = hex.DecodeString(reply.Blob)
-	s.blockTemplate.Store(&newTemplate)
-	return true
+	if info := r.Info(); info != nil {
+		t.txPoolSize = info.TxPoolSize
+	}
+	t.buffer, _ = hex.DecodeString(reply.Blob)
+	return t
+}
+
+// templateRefreshReason tells why fresh template at the same height should replace current one, empty if it shouldn't
+func (s *StratumServer) templateRefreshReason(t *BlockTemplate, r *rpc.RPCClient, reply *rpc.GetBlockTemplateReply) string {
+	cfg := &s.config.TemplateRefresh
+	if t.upstream != r.Name {
+		return "upstream switched"
+	}
+	if s.templateRefreshIntv > 0 && util.MakeTimestamp()-t.createdAt >= int64(s.templateRefreshIntv/time.Millisecond) {
+		return "refresh interval"
+	}
+	if cfg.RewardIncrease > 0 && t.expectedReward > 0 {
+		increase := float64(reply.ExpectedReward-t.expectedReward) / float64(t.expectedReward) * 100
+		if increase >= cfg.RewardIncrease {
+			return fmt.Sprintf("reward increased by %.2f%%", increase)
+		}
+	}
+	if info := r.Info(); cfg.TxPoolGrowth > 0 && info != nil && info.TxPoolSize-t.txPoolSize >= cfg.TxPoolGrowth {
+		return fmt.Sprintf("tx pool grew to %d", info.TxPoolSize)
+	}
+	return ""
+}
+
+// expired reports whether replaced template is past the overlap window and shares for it are stale
+func (b *BlockTemplate) expired(overlap time.Duration) bool {
+	replacedAt := atomic.LoadInt64(&b.replacedAt)
+	return replacedAt > 0 && util.MakeTimestamp()-replacedAt > int64(overlap/time.Millisecond)
+}
+
+type blockCandidate struct {
+	hash      string
+	height    int64
+	diffInt64 int64
+	blob      []byte
+	miner     *Miner
+	ip        string
+	solo      bool
+}
+
+func (b *blockCandidate) logger(l *logging.Logger) *logging.Logger {
+	return l.With(logging.Fields{"height": b.height, "worker": b.miner.id, "ip": b.ip})
+}
+
+// processBlock journals block candidate and submits it, failed submissions are retried in background
+func (s *StratumServer) processBlock(b *blockCandidate) {
+	s.journal.append(&journalEntry{
+		Hash:      b.hash,
+		Timestamp: util.MakeTimestamp(),
+		Height:    b.height,
+		Miner:     b.miner.id,
+		Ip:        b.ip,
+		Blob:      hex.EncodeToString(b.blob),
+	})
+
+	accepted, err := s.submitBlock(b.blob, b.height)
+	if err == nil {
+		s.blockAccepted(b, accepted)
+		return
+	}
+	b.logger(s.blockLog).Errorf("Block %s rejected: %v", b.hash[0:6], err)
+
+	if s.config.BlockSubmit.Retries > 0 {
+		go s.retryBlock(b, err)
+	} else {
+		s.blockRejected(b, err.Error())
+	}
+}
+
+// retryBlock resubmits block with exponential backoff rotating over upstreams while its height is still current
+func (s *StratumServer) retryBlock(b *blockCandidate, err error) {
+	reason := err.Error()
+	backoff := s.retryBackoff
+	offset := int(atomic.LoadInt32(&s.upstream))
+
+	for i := 1; i <= s.config.BlockSubmit.Retries; i++ {
+		time.Sleep(backoff)
+		backoff *= 2
+
+		if t := s.currentBlockTemplate(); t == nil || t.height != b.height {
+			b.logger(s.blockLog).Errorf("Giving up on block %s, height is no longer current", b.hash[0:6])
+			reason = "height is no longer current"
+			break
+		}
+		r := s.upstreams[(offset+i)%len(s.upstreams)]
+		b.logger(s.blockLog).With(logging.Fields{"upstream": r.Name}).Warnf("Retrying block %s submission, attempt %d", b.hash[0:6], i)
+		accepted, err := s.submitBlockTo([]*rpc.RPCClient{r}, b.blob, b.height)
+		if err == nil {
+			s.blockAccepted(b, accepted)
+			return
+		}
+		reason = err.Error()
+	}
+	s.blockRejected(b, reason)
+}
+
+func (s *StratumServer) blockAccepted(b *blockCandidate, accepted []string) {
+	now := util.MakeTimestamp()
+	entry := blockEntry{height: b.height, hash: b.hash, upstreams: accepted}
+
+	// Solo block is credited to its miner entirely and doesn't end pool round
+	if b.solo {
+		entry.address = b.miner.address
+	} else {
+		roundShares := atomic.SwapInt64(&s.roundShares, 0)
+		entry.variance = float64(roundShares) / float64(b.diffInt64)
+	}
+	s.blocksMu.Lock()
+	s.blockStats[now] = entry
+	s.blocksMu.Unlock()
+	atomic.AddInt64(&b.miner.accepts, 1)
+	s.journal.append(&journalEntry{Hash: b.hash, Timestamp: now, Height: b.height, Status: "accepted", Upstreams: accepted})
+	if b.solo {
+		b.logger(s.blockLog).Infof("Solo block %s found, accepted by %v", b.hash[0:6], accepted)
+	} else {
+		b.logger(s.blockLog).Infof("Block %s found with ratio %.4f, accepted by %v", b.hash[0:6], entry.variance, accepted)
+	}
+	s.bus.Publish(s.coin.name, events.BlockFound, &events.BlockFoundData{
+		Hash: b.hash, Height: b.height, Address: b.miner.address, Worker: b.miner.id, Ip: b.ip,
+		Solo: b.solo, Variance: entry.variance, Upstreams: accepted,
+	})
+
+	// Immediately refresh current BT and send new jobs
+	s.refreshBlockTemplate(true)
+}
+
+func (s *StratumServer) blockRejected(b *blockCandidate, reason string) {
+	atomic.AddInt64(&b.miner.rejects, 1)
+	entry := blockEntry{height: b.height, hash: b.hash, rejected: true}
+	if b.solo {
+		entry.address = b.miner.address
+	}
+	s.blocksMu.Lock()
+	s.blockStats[util.MakeTimestamp()] = entry
+	s.blocksMu.Unlock()
+	s.journal.append(&journalEntry{Hash: b.hash, Timestamp: util.MakeTimestamp(), Height: b.height, Status: "rejected"})
+	s.bus.Publish(s.coin.name, events.BlockRejected, &events.BlockRejectedData{
+		Hash: b.hash, Height: b.height, Address: b.miner.address, Worker: b.miner.id, Ip: b.ip, Reason: reason,
+	})
+}
+
+// submitBlock sends block to current upstream or, in broadcast mode, to all healthy upstreams at once.
+// Returns names of upstreams which accepted the block, block is considered accepted if any did.
+func (s *StratumServer) submitBlock(blob []byte, height int64) ([]string, error) {
+	current := s.rpc()
+	targets := []*rpc.RPCClient{current}
+	if s.config.BlockSubmit.Broadcast {
+		for _, v := range s.upstreams {
+			if v != current && !v.Sick() {
+				targets = append(targets, v)
+			}
+		}
+	}
+	return s.submitBlockTo(targets, blob, height)
+}
+
+func (s *StratumServer) submitBlockTo(targets []*rpc.RPCClient, blob []byte, height int64) ([]string, error) {
+	blobHex := hex.EncodeToString(blob)
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+
+	for i, r := range targets {
+		wg.Add(1)
+		go func(i int, r *rpc.RPCClient) {
+			defer wg.Done()
+			_, errs[i] = r.SubmitBlock(blobHex)
+			if errs[i] != nil {
+				atomic.AddInt64(&r.Rejects, 1)
+				s.blockLog.With(logging.Fields{"height": height, "upstream": r.Name}).Errorf("Block rejected: %v", errs[i])
+			} else {
+				atomic.AddInt64(&r.Accepts, 1)
+				atomic.StoreInt64(&r.LastSubmissionAt, util.MakeTimestamp())
+			}
+		}(i, r)
+	}
+	wg.Wait()
+
+	var accepted []string
+	for i, r := range targets {
+		if errs[i] == nil {
+			accepted = append(accepted, r.Name)
+		}
+	}
+	if len(accepted) == 0 {
+		return nil, errs[0]
+	}
+	return accepted, nil
 }
@@ -0,0 +1,92 @@
+package stratum
+
+import (
+	"sort"
+	"time"
+
+	"github.com/sammy007/monero-stratum/cnutil"
+	"github.com/sammy007/monero-stratum/hashing"
+	"github.com/sammy007/monero-stratum/pool"
+	"github.com/sammy007/monero-stratum/util"
+)
+
+// Monero layout is used unless coin profile overrides it
+const (
+	defaultCoinName    = "monero"
+	defaultNonceOffset = 39
+	defaultBlockTime   = 2 * time.Minute
+	defaultDecimals    = 12
+)
+
+// Parameters of chains keeping Monero block format and CryptoNight family of Monero hashing library,
+// blobs are always converted the Monero way
+type coinProfile struct {
+	name        string
+	prefixes    map[uint64]bool
+	nonceOffset int
+	variants    []pool.PowVariant
+	blockTime   time.Duration
+	decimals    int
+}
+
+func newCoinProfile(cfg *pool.Coin) *coinProfile {
+	c := &coinProfile{name: cfg.Name, nonceOffset: cfg.NonceOffset, prefixes: make(map[uint64]bool)}
+	if len(c.name) == 0 {
+		c.name = defaultCoinName
+	}
+	if c.nonceOffset <= 0 {
+		c.nonceOffset = defaultNonceOffset
+	}
+	c.blockTime, _ = time.ParseDuration(cfg.BlockTime)
+	if c.blockTime <= 0 {
+		c.blockTime = defaultBlockTime
+	}
+	c.decimals = cfg.Decimals
+	if c.decimals <= 0 {
+		c.decimals = defaultDecimals
+	}
+	for _, v := range cfg.AddressPrefixes {
+		c.prefixes[v] = true
+	}
+	c.variants = make([]pool.PowVariant, len(cfg.PowVariants))
+	copy(c.variants, cfg.PowVariants)
+	sort.Slice(c.variants, func(i, j int) bool { return c.variants[i].Height < c.variants[j].Height })
+	return c
+}
+
+// validAddress checks address checksum and prefix against allowed ones, any prefix is allowed if none configured
+func (c *coinProfile) validAddress(addr string) bool {
+	prefix, ok := cnutil.DecodeAddress(addr)
+	if !ok {
+		return false
+	}
+	return len(c.prefixes) == 0 || c.prefixes[prefix]
+}
+
+// validMinerAddress is used for pool logins, without configured prefixes miner address must look like pool address
+func (c *coinProfile) validMinerAddress(addr, poolAddr string) bool {
+	if len(c.prefixes) == 0 {
+		return util.ValidateAddress(addr, poolAddr)
+	}
+	return c.validAddress(addr)
+}
+
+// powVariant returns variant of the last fork at or below height, -1 means derive it from blob major version
+func (c *coinProfile) powVariant(height int64) int {
+	variant := -1
+	for _, v := range c.variants {
+		if v.Height > height {
+			break
+		}
+		variant = v.Variant
+	}
+	return variant
+}
+
+// hash computes PoW hash of hashing blob with variant of the chain at height
+func (c *coinProfile) hash(blob []byte, height int64) []byte {
+	if variant := c.powVariant(height); variant >= 0 {
+		return hashing.HashVariant(blob, variant, height)
+	}
+	return hashing.Hash(blob, false, height)
+}
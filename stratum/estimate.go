@@ -0,0 +1,28 @@
+package stratum
+
+import "sync/atomic"
+
+// networkStats estimates network hashrate from template difficulty and block target time.
+// Pool hashrate excludes solo miners, since their shares don't take part in pool round.
+func (s *StratumServer) networkStats(t *BlockTemplate, poolHashrate float64) map[string]interface{} {
+	blockTime := s.coin.blockTime.Seconds()
+	networkHashrate := float64(t.diffInt64) / blockTime
+	stats := map[string]interface{}{
+		"hashrate":    networkHashrate,
+		"blockTime":   blockTime,
+		"blockReward": t.expectedReward,
+		"decimals":    s.coin.decimals,
+		"poolShare":   poolHashrate / networkHashrate * 100,
+		"roundEffort": float64(atomic.LoadInt64(&s.roundShares)) / float64(t.diffInt64) * 100,
+	}
+	// Seconds pool needs on average to find block at its current hashrate
+	if poolHashrate > 0 {
+		stats["expectedBlockTime"] = float64(t.diffInt64) / poolHashrate
+	}
+	return stats
+}
+
+// dailyEarnings is expected reward of hashrate per day in atomic units, at current difficulty and block reward
+func dailyEarnings(t *BlockTemplate, hashrate float64) float64 {
+	return hashrate * 86400 / float64(t.diffInt64) * float64(t.expectedReward)
+}
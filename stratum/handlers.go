@@ -1,11 +1,13 @@
 package stratum
 
 import (
-	"log"
+	"encoding/hex"
 	"regexp"
 	"strings"
 	"sync/atomic"
 
+	"github.com/sammy007/monero-stratum/events"
+	"github.com/sammy007/monero-stratum/logging"
 	"github.com/sammy007/monero-stratum/util"
 )
 
@@ -18,37 +20,75 @@ func init() {
 }
 
 func (s *StratumServer) handleLoginRPC(cs *Session, params *LoginParams) (*JobReply, *ErrorReply) {
-	address, id := extractWorkerId(params.Login)
-	if !s.config.BypassAddressValidation && !util.ValidateAddress(address, s.config.Address) {
-		log.Printf("Invalid address %s used for login by %s", address, cs.ip)
+	address, id, solo := s.parseLogin(params.Login, cs.endpoint.config.Solo)
+	agent := normalizeAgent(params.Agent)
+	if message, ok := s.agentPolicy.check(agent); !ok {
+		cs.logger(s.log, nil).With(logging.Fields{"agent": agent}).Warnf("Refused mining software")
+		return nil, &ErrorReply{Code: -1, Message: message}
+	}
+	cs.setAgent(agent)
+
+	if solo && s.config.Proxy.Enabled {
+		return nil, &ErrorReply{Code: -1, Message: "Solo mining is not available"}
+	}
+	if solo {
+		// Daemon builds solo templates for this address, so it must be valid regardless of bypass
+		if !s.coin.validAddress(address) {
+			cs.logger(s.log, nil).Warnf("Invalid solo address %s used for login", address)
+			return nil, &ErrorReply{Code: -1, Message: "Invalid address used for login"}
+		}
+		cs.soloAddress = address
+	} else if !s.config.BypassAddressValidation && !s.coin.validMinerAddress(address, s.config.Address) {
+		cs.logger(s.log, nil).Warnf("Invalid address %s used for login", address)
 		return nil, &ErrorReply{Code: -1, Message: "Invalid address used for login"}
 	}
 
-	t := s.currentBlockTemplate()
+	t := s.sessionTemplate(cs)
 	if t == nil {
 		return nil, &ErrorReply{Code: -1, Message: "Job not ready"}
 	}
+	if cs.address != address {
+		if !s.admission.acquireAddress(address) {
+			s.admission.refuse(refusedPerAddress)
+			cs.logger(s.log, nil).With(logging.Fields{"address": address}).Warnf("Too many connections of address")
+			return nil, &ErrorReply{Code: -1, Message: "Too many connections"}
+		}
+		s.admission.releaseAddress(cs.address)
+		cs.address = address
+	}
+	if s.config.Proxy.Enabled && !s.allocProxySlot(cs) {
+		cs.logger(s.log, nil).With(logging.Fields{"worker": id}).Warnf("No free nonce slots left")
+		return nil, &ErrorReply{Code: -1, Message: "Proxy is full"}
+	}
 
-	miner, ok := s.miners.Get(id)
+	miner, ok := s.registerMiner(id, cs.ip, address, solo)
 	if !ok {
-		miner = NewMiner(id, cs.ip)
-		s.registerMiner(miner)
+		cs.logger(s.log, nil).With(logging.Fields{"address": address, "worker": id}).Warnf("Too many workers of address")
+		return nil, &ErrorReply{Code: -1, Message: "Too many workers"}
 	}
 
-	log.Printf("Miner connected %s@%s", id, cs.ip)
+	l := cs.logger(s.log, miner).With(logging.Fields{"agent": agent})
+	if solo {
+		l.Infof("Solo miner connected")
+	} else {
+		l.Infof("Miner connected")
+	}
 
+	miner.setAgent(agent)
+	cs.attach(miner)
 	s.registerSession(cs)
-	miner.heartbeat()
+	s.bus.Publish(s.coin.name, events.MinerLogin, &events.MinerLoginData{Address: address, Worker: id, Ip: cs.ip, Port: cs.endpoint.config.Port, Agent: agent, Solo: solo})
 
 	return &JobReply{Id: id, Job: cs.getJob(t), Status: "OK"}, nil
 }
 
+// Session is bound to worker it logged in as, id sent back by miner is only informational
 func (s *StratumServer) handleGetJobRPC(cs *Session, params *GetJobParams) (*JobReplyData, *ErrorReply) {
-	miner, ok := s.miners.Get(params.Id)
-	if !ok {
+	miner := cs.miner
+	if miner == nil {
 		return nil, &ErrorReply{Code: -1, Message: "Unauthenticated"}
 	}
-	t := s.currentBlockTemplate()
+	t := s.sessionTemplate(cs)
 	if t == nil {
 		return nil, &ErrorReply{Code: -1, Message: "Job not ready"}
 	}
@@ -56,17 +96,41 @@ func (s *StratumServer) handleGetJobRPC(cs *Session, params *GetJobParams) (*Job
 	return cs.getJob(t), nil
 }
 
-func (s *StratumServer) handleSubmitRPC(cs *Session, params *SubmitParams) (*StatusReply, *ErrorReply) {
-	miner, ok := s.miners.Get(params.Id)
-	if !ok {
+func (s *StratumServer) handleSubmitRPC(cs *Session, params *SubmitParams) (reply *StatusReply, errReply *ErrorReply) {
+	var rec *shareRecord
+	if s.shareAudit != nil {
+		rec = &shareRecord{Timestamp: util.MakeTimestamp(), Worker: params.Id, Ip: cs.ip, Port: cs.endpoint.config.Port, JobId: params.JobId}
+		claimedHash, _ := hex.DecodeString(params.Result)
+		if diff, ok := util.GetHashDifficulty(claimedHash); ok {
+			rec.ClaimedDiff = diff.Int64()
+		}
+		defer func() {
+			rec.Outcome = shareValid
+			if errReply != nil {
+				rec.Outcome, rec.Reason = shareInvalid, errReply.Message
+			} else if rec.block {
+				rec.Outcome = shareBlock
+			}
+			s.shareAudit.write(rec)
+		}()
+	}
+
+	miner := cs.miner
+	if miner == nil {
 		return nil, &ErrorReply{Code: -1, Message: "Unauthenticated"}
 	}
 	miner.heartbeat()
+	if rec != nil {
+		rec.Worker, rec.Address = miner.id, miner.address
+	}
 
 	job := cs.findJob(params.JobId)
 	if job == nil {
 		return nil, &ErrorReply{Code: -1, Message: "Invalid job id"}
 	}
+	if rec != nil {
+		rec.Height = job.height
+	}
 
 	if !noncePattern.MatchString(params.Nonce) {
 		return nil, &ErrorReply{Code: -1, Message: "Malformed nonce"}
@@ -78,34 +142,34 @@ func (s *StratumServer) handleSubmitRPC(cs *Session, params *SubmitParams) (*Sta
 		return nil, &ErrorReply{Code: -1, Message: "Duplicate share"}
 	}
 
+	// Shares for replaced template at the same height are still valid within overlap window
 	t := s.currentBlockTemplate()
-	if job.height != t.height {
-		log.Printf("Stale share for height %d from %s@%s", job.height, miner.id, cs.ip)
+	if job.height != t.height || job.template.expired(s.shareOverlap(job.template)) {
+		cs.logger(s.shareLog, miner).With(logging.Fields{"height": job.height}).Infof("Stale share")
 		atomic.AddInt64(&miner.staleShares, 1)
 		return nil, &ErrorReply{Code: -1, Message: "Block expired"}
 	}
 
-	validShare := miner.processShare(s, cs, job, t, nonce, params.Result)
-	if !validShare {
-		return nil, &ErrorReply{Code: -1, Message: "Low difficulty share"}
+	errReply = miner.processShare(s, cs, job, job.template, nonce, params.Result, rec)
+	if errReply != nil {
+		return nil, errReply
 	}
 	return &StatusReply{Status: "OK"}, nil
 }
 
-func (s *StratumServer) handleUnknownRPC(req *JSONRpcReq) *ErrorReply {
-	log.Printf("Unknown RPC method: %v", req)
+func (s *StratumServer) handleUnknownRPC(cs *Session, req *JSONRpcReq) *ErrorReply {
+	cs.logger(s.log, nil).Warnf("Unknown RPC method: %v", req.Method)
 	return &ErrorReply{Code: -1, Message: "Invalid method"}
 }
 
 func (s *StratumServer) broadcastNewJobs() {
-	t := s.currentBlockTemplate()
-	if t == nil {
+	if s.currentBlockTemplate() == nil {
 		return
 	}
 	s.sessionsMu.RLock()
 	defer s.sessionsMu.RUnlock()
 	count := len(s.sessions)
-	log.Printf("Broadcasting new jobs to %d miners", count)
+	s.log.Infof("Broadcasting new jobs to %d miners", count)
 	bcast := make(chan int, 1024*16)
 	n := 0
 
@@ -113,11 +177,16 @@ func (s *StratumServer) broadcastNewJobs() {
 		n++
 		bcast <- n
 		go func(cs *Session) {
+			t := s.sessionTemplate(cs)
+			if t == nil {
+				<-bcast
+				return
+			}
 			reply := cs.getJob(t)
 			err := cs.pushMessage("job", &reply)
 			<-bcast
 			if err != nil {
-				log.Printf("Job transmit error to %s: %v", cs.ip, err)
+				cs.logger(s.log, nil).Warnf("Job transmit error: %v", err)
 				s.removeSession(cs)
 			} else {
 				s.setDeadline(cs.conn)
@@ -133,6 +202,20 @@ func (s *StratumServer) refreshBlockTemplate(bcast bool) {
 	}
 }
 
+// parseLogin splits login into address and worker id, login with solo prefix or to solo port mines solo.
+// Solo worker id includes address to keep stats of solo miners apart from each other.
+func (s *StratumServer) parseLogin(login string, soloPort bool) (string, string, bool) {
+	solo := soloPort
+	if prefix := s.config.SoloLoginPrefix; len(prefix) > 0 && strings.HasPrefix(login, prefix) {
+		login, solo = strings.TrimPrefix(login, prefix), true
+	}
+	address, id := extractWorkerId(login)
+	if solo {
+		id = address + "." + id
+	}
+	return address, id, solo
+}
+
 func extractWorkerId(loginWorkerPair string) (string, string) {
 	parts := strings.SplitN(loginWorkerPair, ".", 2)
 	if len(parts) > 1 {
@@ -0,0 +1,39 @@
+package stratum
+
+import (
+	"testing"
+
+	"github.com/sammy007/monero-stratum/pool"
+)
+
+func TestParseLogin(t *testing.T) {
+	s := newTestServer(&pool.Config{SoloLoginPrefix: "solo:"})
+	tests := []struct {
+		login    string
+		soloPort bool
+		address  string
+		id       string
+		solo     bool
+	}{
+		{"A", false, "A", "0", false},
+		{"A.rig", false, "A", "rig", false},
+		{"A.rig.1", false, "A", "rig.1", false},
+		{"solo:A", false, "A", "A.0", true},
+		{"solo:A.rig", false, "A", "A.rig", true},
+		{"A.rig", true, "A", "A.rig", true},
+		{"solo:A.rig", true, "A", "A.rig", true},
+		// Prefix only counts at the start of login
+		{"A.solo:rig", false, "A", "solo:rig", false},
+	}
+	for i, tt := range tests {
+		address, id, solo := s.parseLogin(tt.login, tt.soloPort)
+		if address != tt.address || id != tt.id || solo != tt.solo {
+			t.Errorf("#%d: expected %s %s %v, got %s %s %v", i, tt.address, tt.id, tt.solo, address, id, solo)
+		}
+	}
+
+	s.config.SoloLoginPrefix = ""
+	if address, _, solo := s.parseLogin("solo:A", false); solo || address != "solo:A" {
+		t.Error("Solo login is not disabled")
+	}
+}
@@ -0,0 +1,83 @@
+package stratum
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/sammy007/monero-stratum/logging"
+)
+
+// Append-only journal of raw block candidates and their submission outcomes, one JSON entry per line
+type blockJournal struct {
+	sync.Mutex
+	path string
+	log  *logging.Logger
+}
+
+type journalEntry struct {
+	Hash      string   `json:"hash"`
+	Timestamp int64    `json:"timestamp"`
+	Height    int64    `json:"height"`
+	Miner     string   `json:"miner,omitempty"`
+	Ip        string   `json:"ip,omitempty"`
+	Blob      string   `json:"blob,omitempty"`
+	Status    string   `json:"status,omitempty"`
+	Upstreams []string `json:"upstreams,omitempty"`
+}
+
+func newBlockJournal(path string, l *logging.Logger) *blockJournal {
+	if len(path) == 0 {
+		return nil
+	}
+	l.Infof("Journaling block candidates to %s", path)
+	return &blockJournal{path: path, log: l}
+}
+
+func (j *blockJournal) append(entry *journalEntry) {
+	if j == nil {
+		return
+	}
+	data, _ := json.Marshal(entry)
+
+	j.Lock()
+	defer j.Unlock()
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		j.log.Errorf("Unable to open block journal: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err = f.Write(append(data, '\n')); err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		j.log.Errorf("Unable to write block journal: %v", err)
+	}
+}
+
+// find returns journaled block candidate with given hash
+func (j *blockJournal) find(hash string) (*journalEntry, error) {
+	j.Lock()
+	defer j.Unlock()
+	f, err := os.Open(j.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var result *journalEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if entry.Hash == hash && len(entry.Blob) > 0 {
+			result = &entry
+		}
+	}
+	return result, scanner.Err()
+}
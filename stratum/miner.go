@@ -4,7 +4,8 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/hex"
-	"log"
+	"math/big"
+	"math/rand"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -12,12 +13,18 @@ import (
 
 	"github.com/sammy007/monero-stratum/cnutil"
 	"github.com/sammy007/monero-stratum/hashing"
+	"github.com/sammy007/monero-stratum/logging"
+	"github.com/sammy007/monero-stratum/pool"
 	"github.com/sammy007/monero-stratum/util"
 )
 
+// Consecutive verified shares required to trust miner if not configured
+const defaultTrustThreshold = 100
+
 type Job struct {
-	height      int64
+	height int64
 	sync.RWMutex
+	template    *BlockTemplate
 	id          string
 	extraNonce  uint32
 	submissions map[string]struct{}
@@ -31,10 +38,16 @@ type Miner struct {
 	staleShares   int64
 	accepts       int64
 	rejects       int64
+	trust         int64
 	shares        map[int64]int64
 	sync.RWMutex
-	id            string
-	ip            string
+	sessions map[*Session]struct{}
+	key      string
+	id       string
+	ip       string
+	address  string
+	agent    string
+	solo     bool
 }
 
 func (job *Job) submit(nonce string) bool {
@@ -47,32 +60,67 @@ func (job *Job) submit(nonce string) bool {
 	return false
 }
 
-func NewMiner(id string, ip string) *Miner {
+func NewMiner(id string, ip string, address string, solo bool) *Miner {
 	shares := make(map[int64]int64)
-	return &Miner{id: id, ip: ip, shares: shares}
+	return &Miner{key: minerKey(id, address, solo), id: id, ip: ip, address: address, solo: solo, shares: shares, sessions: make(map[*Session]struct{})}
+}
+
+// minerKey keeps workers of different addresses apart even if they use the same name, solo ids
+// already hold address but get namespace of their own, so pool login can't take solo worker over
+func minerKey(id, address string, solo bool) string {
+	if solo {
+		return "solo:" + id
+	}
+	return "pool:" + address + "." + id
 }
 
 func (cs *Session) getJob(t *BlockTemplate) *JobReplyData {
-	height := atomic.SwapInt64(&cs.lastBlockHeight, t.height)
+	cs.Lock()
+	last := cs.lastTemplate
+	cs.lastTemplate = t
+	cs.Unlock()
 
-	if height == t.height {
+	if last == t {
 		return &JobReplyData{}
 	}
 
-	extraNonce := atomic.AddUint32(&cs.endpoint.extraNonce, 1)
-	blob := t.nextBlob(extraNonce, cs.endpoint.instanceId)
+	var blob string
+	var extraNonce uint32
+	if t.proxy {
+		blob = hex.EncodeToString(t.proxyBlob(cs.proxySlot))
+	} else {
+		extraNonce = atomic.AddUint32(&cs.endpoint.extraNonce, 1)
+		blob = t.nextBlob(extraNonce, cs.endpoint.instanceId)
+	}
 	id := atomic.AddUint64(&cs.endpoint.jobSequence, 1)
 	job := &Job{
 		id:         strconv.FormatUint(id, 10),
 		extraNonce: extraNonce,
 		height:     t.height,
+		template:   t,
 	}
 	job.submissions = make(map[string]struct{})
+	atomic.StoreInt64(&cs.difficulty, cs.shareDifficulty(t))
 	cs.pushJob(job)
-	reply := &JobReplyData{JobId: job.id, Blob: blob, Target: cs.endpoint.targetHex}
+	reply := &JobReplyData{JobId: job.id, Blob: blob, Target: cs.targetHex(t)}
 	return reply
 }
 
+// shareDifficulty is port difficulty, limited by upstream pool job difficulty in proxy mode
+func (cs *Session) shareDifficulty(t *BlockTemplate) int64 {
+	if t.proxy && t.diffInt64 < cs.endpoint.config.Difficulty {
+		return t.diffInt64
+	}
+	return cs.endpoint.config.Difficulty
+}
+
+func (cs *Session) targetHex(t *BlockTemplate) string {
+	if diff := cs.shareDifficulty(t); diff != cs.endpoint.config.Difficulty {
+		return util.GetTargetHex(diff)
+	}
+	return cs.endpoint.targetHex
+}
+
 func (cs *Session) pushJob(job *Job) {
 	cs.Lock()
 	defer cs.Unlock()
@@ -81,6 +129,7 @@ func (cs *Session) pushJob(job *Job) {
 	if len(cs.validJobs) > 4 {
 		cs.validJobs = cs.validJobs[1:]
 	}
+	atomic.StoreInt32(&cs.jobs, int32(len(cs.validJobs)))
 }
 
 func (cs *Session) findJob(id string) *Job {
@@ -120,89 +169,198 @@ func (m *Miner) hashrate(estimationWindow time.Duration) float64 {
 		boundary = window
 	}
 
-	m.Lock()
+	m.pruneShares(now)
+	m.RLock()
 	for k, v := range m.shares {
+		if k >= now-window {
+			totalShares += v
+		}
+	}
+	m.RUnlock()
+	return float64(totalShares) / float64(boundary)
+}
+
+// pruneShares drops per-second share buckets older than a day
+func (m *Miner) pruneShares(now int64) {
+	m.Lock()
+	for k := range m.shares {
 		if k < now-86400 {
 			delete(m.shares, k)
-		} else if k >= now-window {
-			totalShares += v
 		}
 	}
 	m.Unlock()
-	return float64(totalShares) / float64(boundary)
 }
 
-func (m *Miner) processShare(s *StratumServer, cs *Session, job *Job, t *BlockTemplate, nonce string, result string) bool {
-	r := s.rpc()
+type hashratePoint struct {
+	Timestamp int64   `json:"timestamp"`
+	Hashrate  float64 `json:"hashrate"`
+}
 
-	shareBuff := make([]byte, len(t.buffer))
-	copy(shareBuff, t.buffer)
-	copy(shareBuff[t.reservedOffset+4:t.reservedOffset+7], cs.endpoint.instanceId)
+// Address charts cover last day in half-hour points
+const (
+	chartWindow = 24 * time.Hour
+	chartStep   = 30 * time.Minute
+)
 
-	extraBuff := new(bytes.Buffer)
-	binary.Write(extraBuff, binary.BigEndian, job.extraNonce)
-	copy(shareBuff[t.reservedOffset:], extraBuff.Bytes())
+// hashrateSeries splits window into steps and returns average hashrate of each, the last point ends now
+func (m *Miner) hashrateSeries(window, step time.Duration) []hashratePoint {
+	now := util.MakeTimestamp() / 1000
+	stepSec := int64(step / time.Second)
+	from := now - int64(window/time.Second)
+	points := make([]hashratePoint, window/step)
+	for i := range points {
+		points[i].Timestamp = (from + int64(i+1)*stepSec) * 1000
+	}
 
-	nonceBuff, _ := hex.DecodeString(nonce)
-	copy(shareBuff[39:], nonceBuff)
+	m.RLock()
+	for k, v := range m.shares {
+		if i := (k - from - 1) / stepSec; k > from && i < int64(len(points)) {
+			points[i].Hashrate += float64(v)
+		}
+	}
+	m.RUnlock()
+	for i := range points {
+		points[i].Hashrate /= float64(stepSec)
+	}
+	return points
+}
 
-	var hashBytes, convertedBlob []byte
+// trusted reports whether miner has passed enough consecutive verified shares
+func (m *Miner) trusted(threshold int64) bool {
+	return atomic.LoadInt64(&m.trust) >= threshold
+}
 
+// checkRatio is fraction of trusted miners' shares to verify. Without configured threshold
+// shares of all miners are verified, default threshold only orders validation queue.
+func checkRatio(cfg *pool.ShareValidation) float64 {
+	if cfg.TrustThreshold <= 0 || cfg.CheckRatio > 1 {
+		return 1
+	}
+	return cfg.CheckRatio
+}
+
+// verifyShare tells whether share of miner is hashed, only random sample of trusted miners' shares is
+func (s *StratumServer) verifyShare(m *Miner) bool {
 	if s.config.BypassShareValidation {
-		hashBytes, _ = hex.DecodeString(result)
+		return false
+	}
+	if s.checkRatio < 1 && m.trusted(s.trustThreshold) {
+		return rand.Float64() < s.checkRatio
+	}
+	return true
+}
+
+func (m *Miner) processShare(s *StratumServer, cs *Session, job *Job, t *BlockTemplate, nonce string, result string, rec *shareRecord) *ErrorReply {
+	nonceBuff, _ := hex.DecodeString(nonce)
+	var hashBytes, shareBuff, convertedBlob []byte
+
+	if t.proxy {
+		// Miner must keep highest nonce byte of its slot to not overlap with others
+		if nonceBuff[3] != cs.proxySlot {
+			cs.logger(s.shareLog, m).Warnf("Nonce out of assigned range")
+			atomic.AddInt64(&m.invalidShares, 1)
+			return &ErrorReply{Code: -1, Message: "Low difficulty share"}
+		}
+		convertedBlob = t.proxyBlob(cs.proxySlot)
+		copy(convertedBlob[t.coin.nonceOffset:], nonceBuff)
 	} else {
-		convertedBlob = cnutil.ConvertBlob(shareBuff)
-		hashBytes = hashing.Hash(convertedBlob, false, t.height)
+		shareBuff = make([]byte, len(t.buffer))
+		copy(shareBuff, t.buffer)
+		copy(shareBuff[t.reservedOffset+4:t.reservedOffset+7], cs.endpoint.instanceId)
+
+		extraBuff := new(bytes.Buffer)
+		binary.Write(extraBuff, binary.BigEndian, job.extraNonce)
+		copy(shareBuff[t.reservedOffset:], extraBuff.Bytes())
+		copy(shareBuff[t.coin.nonceOffset:], nonceBuff)
 	}
 
-	if !s.config.BypassShareValidation && hex.EncodeToString(hashBytes) != result {
-		log.Printf("Bad hash from miner %v@%v", m.id, cs.ip)
+	claimedHash, _ := hex.DecodeString(result)
+	verify := s.verifyShare(m)
+	// Block candidates are always verified to never submit garbage
+	claimedDiff, ok := util.GetHashDifficulty(claimedHash)
+	candidate := ok && claimedDiff.Cmp(t.difficulty) >= 0
+	verify = verify || candidate
+
+	if verify {
+		var err error
+		// Daemon needs full block blob, proxy jobs only have hashing blob.
+		// Daemon call only waits on network, so it doesn't take validation worker, rate limit bounds it instead.
+		if s.verifier != nil && !t.proxy {
+			hashBytes, err = s.verifier.hash(s, t, shareBuff, candidate)
+		} else if !s.validator.run(!m.trusted(s.trustThreshold), func() {
+			if len(convertedBlob) == 0 {
+				convertedBlob = cnutil.ConvertBlob(shareBuff)
+			}
+			hashBytes = t.coin.hash(convertedBlob, t.height)
+		}) {
+			return &ErrorReply{Code: -1, Message: "Server busy"}
+		}
+		// Share that had to be verified is never credited unverified, even if daemon is rate limited or failed
+		if hashBytes == nil && candidate {
+			cs.logger(s.blockLog, m).With(logging.Fields{"height": t.height}).Errorf("Unable to verify block candidate: %v", err)
+			return &ErrorReply{Code: -1, Message: "Unable to verify share"}
+		} else if hashBytes == nil {
+			cs.logger(s.shareLog, m).Debugf("Unable to verify share: %v", err)
+			return &ErrorReply{Code: -1, Message: "Server busy"}
+		}
+	}
+
+	// Only share of trusted miner left out of sample is taken as is
+	verified := verify
+	if !verified {
+		hashBytes = claimedHash
+	} else if hex.EncodeToString(hashBytes) != result {
+		cs.logger(s.shareLog, m).Warnf("Bad hash")
 		atomic.AddInt64(&m.invalidShares, 1)
-		return false
+		atomic.StoreInt64(&m.trust, 0)
+		// Local unix socket clients share single pseudo IP, so they are never banned
+		if !cs.endpoint.unix {
+			s.banIP(cs.ip)
+		}
+		return &ErrorReply{Code: -1, Message: "Low difficulty share"}
+	} else {
+		atomic.AddInt64(&m.trust, 1)
 	}
 
 	hashDiff, ok := util.GetHashDifficulty(hashBytes)
 	if !ok {
-		log.Printf("Bad hash from miner %v@%v", m.id, cs.ip)
+		cs.logger(s.shareLog, m).Warnf("Bad hash")
 		atomic.AddInt64(&m.invalidShares, 1)
-		return false
+		return &ErrorReply{Code: -1, Message: "Low difficulty share"}
 	}
 	block := hashDiff.Cmp(t.difficulty) >= 0
+	shareDiff := cs.shareDifficulty(t)
+	rec.setActual(hashDiff, verified, block)
 
-	if block {
-		_, err := r.SubmitBlock(hex.EncodeToString(shareBuff))
-		if err != nil {
-			atomic.AddInt64(&m.rejects, 1)
-			atomic.AddInt64(&r.Rejects, 1)
-			log.Printf("Block rejected at height %d: %v", t.height, err)
-		} else {
-			if len(convertedBlob) == 0 {
-				convertedBlob = cnutil.ConvertBlob(shareBuff)
-			}
-			blockFastHash := hex.EncodeToString(hashing.FastHash(convertedBlob))
-			now := util.MakeTimestamp()
-			roundShares := atomic.SwapInt64(&s.roundShares, 0)
-			ratio := float64(roundShares) / float64(t.diffInt64)
-			s.blocksMu.Lock()
-			s.blockStats[now] = blockEntry{height: t.height, hash: blockFastHash, variance: ratio}
-			s.blocksMu.Unlock()
-			atomic.AddInt64(&m.accepts, 1)
-			atomic.AddInt64(&r.Accepts, 1)
-			atomic.StoreInt64(&r.LastSubmissionAt, now)
-			log.Printf("Block %s found at height %d by miner %v@%v with ratio %.4f", blockFastHash[0:6], t.height, m.id, cs.ip, ratio)
-
-			// Immediately refresh current BT and send new jobs
-			s.refreshBlockTemplate(true)
+	if block && t.proxy {
+		// Share meets upstream pool job target
+		go s.submitProxyShare(t, m, cs, nonce, hashBytes)
+	} else if block {
+		if len(convertedBlob) == 0 {
+			convertedBlob = cnutil.ConvertBlob(shareBuff)
 		}
-	} else if hashDiff.Cmp(cs.endpoint.difficulty) < 0 {
-		log.Printf("Rejected low difficulty share of %v from %v@%v", hashDiff, m.id, cs.ip)
+		s.processBlock(&blockCandidate{
+			hash:      hex.EncodeToString(hashing.FastHash(convertedBlob)),
+			height:    t.height,
+			diffInt64: t.diffInt64,
+			blob:      shareBuff,
+			miner:     m,
+			ip:        cs.ip,
+			solo:      len(cs.soloAddress) > 0,
+		})
+	} else if hashDiff.Cmp(big.NewInt(shareDiff)) < 0 {
+		cs.logger(s.shareLog, m).Infof("Rejected low difficulty share of %v", hashDiff)
 		atomic.AddInt64(&m.invalidShares, 1)
-		return false
+		return &ErrorReply{Code: -1, Message: "Low difficulty share"}
 	}
 
-	atomic.AddInt64(&s.roundShares, cs.endpoint.config.Difficulty)
+	if len(cs.soloAddress) == 0 {
+		atomic.AddInt64(&s.roundShares, shareDiff)
+	}
 	atomic.AddInt64(&m.validShares, 1)
-	m.storeShare(cs.endpoint.config.Difficulty)
-	log.Printf("Valid share at difficulty %v/%v", cs.endpoint.config.Difficulty, hashDiff)
-	return true
+	m.storeShare(shareDiff)
+	if s.shareLog.Enabled(logging.DebugLevel) {
+		cs.logger(s.shareLog, m).With(logging.Fields{"height": t.height}).Debugf("Valid share at difficulty %v/%v", shareDiff, hashDiff)
+	}
+	return nil
 }
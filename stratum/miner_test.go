@@ -0,0 +1,70 @@
+package stratum
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/sammy007/monero-stratum/pool"
+)
+
+func TestVerifyShare(t *testing.T) {
+	tests := []struct {
+		cfg    pool.ShareValidation
+		bypass bool
+		trust  int64
+		verify bool
+	}{
+		// Threshold not configured, shares of all miners are verified
+		{pool.ShareValidation{CheckRatio: 0}, false, 1000, true},
+		{pool.ShareValidation{TrustThreshold: 10, CheckRatio: 0}, false, 9, true},
+		{pool.ShareValidation{TrustThreshold: 10, CheckRatio: 0}, false, 10, false},
+		{pool.ShareValidation{TrustThreshold: 10, CheckRatio: 1}, false, 10, true},
+		{pool.ShareValidation{TrustThreshold: 10, CheckRatio: 1}, true, 0, false},
+	}
+	for i, tt := range tests {
+		s := newTestServer(&pool.Config{BypassShareValidation: tt.bypass, ShareValidation: tt.cfg})
+		s.trustThreshold, s.checkRatio = defaultTrustThreshold, checkRatio(&tt.cfg)
+		if tt.cfg.TrustThreshold > 0 {
+			s.trustThreshold = tt.cfg.TrustThreshold
+		}
+		m := NewMiner("0", "", "A", false)
+		m.trust = tt.trust
+		if verify := s.verifyShare(m); verify != tt.verify {
+			t.Errorf("#%d: expected %v, got %v", i, tt.verify, verify)
+		}
+	}
+}
+
+func TestDaemonVerifierLimited(t *testing.T) {
+	tests := []struct {
+		checkRatio float64
+		trust      int64
+		reply      string
+		valid      int64
+	}{
+		// Untrusted miner can't get claimed hash credited by exhausting daemon rate limit
+		{0, 0, "Server busy", 0},
+		{0, 10, "", 1},
+		{1, 10, "Server busy", 0},
+	}
+	for i, tt := range tests {
+		cfg := pool.ShareValidation{TrustThreshold: 10, CheckRatio: tt.checkRatio}
+		s := newTestServer(&pool.Config{ShareValidation: cfg})
+		s.trustThreshold, s.checkRatio = cfg.TrustThreshold, checkRatio(&cfg)
+		s.verifier = newDaemonVerifier(1)
+		s.verifier.tokens = 0
+		tpl := &BlockTemplate{buffer: make([]byte, 76), reservedOffset: 60, coin: s.coin, difficulty: big.NewInt(1000000), height: 1}
+		cs := &Session{ip: "127.0.0.1", endpoint: &Endpoint{config: &pool.Port{Difficulty: 1}, instanceId: make([]byte, 3)}}
+		m := NewMiner("0", "", "A", false)
+		m.trust = tt.trust
+
+		reply := ""
+		if err := m.processShare(s, cs, &Job{}, tpl, "00000001", strings.Repeat("ff", 32), &shareRecord{}); err != nil {
+			reply = err.Message
+		}
+		if reply != tt.reply || m.validShares != tt.valid {
+			t.Errorf("#%d: expected %q with %v valid shares, got %q with %v", i, tt.reply, tt.valid, reply, m.validShares)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package stratum
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sammy007/monero-stratum/auth"
+	"github.com/sammy007/monero-stratum/events"
+	"github.com/sammy007/monero-stratum/pool"
+	"github.com/sammy007/monero-stratum/util"
+)
+
+// Pools hosts stratum servers of several coins in one process, stats are namespaced by coin name
+type Pools struct {
+	names   []string
+	servers map[string]*StratumServer
+}
+
+// Pools share event bus, events carry coin name
+func NewPools(cfgs []*pool.Config, bus *events.Bus) *Pools {
+	p := &Pools{servers: make(map[string]*StratumServer)}
+	for _, cfg := range cfgs {
+		s := NewStratum(cfg, bus)
+		name := s.coin.name
+		if _, ok := p.servers[name]; ok {
+			s.log.Fatalf("Duplicate pool for coin %s, coin names must be unique", name)
+		}
+		p.names = append(p.names, name)
+		p.servers[name] = s
+	}
+	return p
+}
+
+func (p *Pools) Listen() {
+	quit := make(chan bool)
+	for _, name := range p.names {
+		go p.servers[name].Listen()
+	}
+	<-quit
+}
+
+// server returns pool of given coin, the first one if coin is empty
+func (p *Pools) server(coin string) (*StratumServer, bool) {
+	if len(coin) == 0 {
+		coin = p.names[0]
+	}
+	s, ok := p.servers[coin]
+	return s, ok
+}
+
+// PoolsIndex lists hosted coins
+func (p *Pools) PoolsIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"pools": p.names})
+}
+
+// StatsIndex keeps single pool stats layout as is, otherwise stats of every pool are keyed by coin
+func (p *Pools) StatsIndex(w http.ResponseWriter, r *http.Request) {
+	if len(p.names) == 1 {
+		p.servers[p.names[0]].StatsIndex(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+
+	pools := make(map[string]interface{})
+	for name, s := range p.servers {
+		pools[name] = s.stats(auth.Redact(r))
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"pools": pools, "now": util.MakeTimestamp()})
+}
+
+// byCoin dispatches request to pool given by coin path variable or, failing that, coin parameter,
+// the first pool is used if both are omitted
+func (p *Pools) byCoin(handler func(*StratumServer, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		coin, ok := mux.Vars(r)["coin"]
+		if !ok {
+			coin = r.URL.Query().Get("coin")
+		}
+		s, ok := p.server(coin)
+		if !ok {
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": "Unknown coin"})
+			return
+		}
+		handler(s, w, r)
+	}
+}
+
+func (p *Pools) CoinStats(w http.ResponseWriter, r *http.Request) {
+	p.byCoin((*StratumServer).StatsIndex)(w, r)
+}
+
+func (p *Pools) Blocks(w http.ResponseWriter, r *http.Request) {
+	p.byCoin((*StratumServer).BlocksIndex)(w, r)
+}
+
+func (p *Pools) Upstreams(w http.ResponseWriter, r *http.Request) {
+	p.byCoin((*StratumServer).UpstreamsIndex)(w, r)
+}
+
+func (p *Pools) AddressStats(w http.ResponseWriter, r *http.Request) {
+	p.byCoin((*StratumServer).AddressStats)(w, r)
+}
+
+func (p *Pools) AlertRules(w http.ResponseWriter, r *http.Request) {
+	p.byCoin((*StratumServer).AlertRules)(w, r)
+}
+
+func (p *Pools) AlertToken(w http.ResponseWriter, r *http.Request) {
+	p.byCoin((*StratumServer).AlertToken)(w, r)
+}
+
+func (p *Pools) ResubmitBlock(w http.ResponseWriter, r *http.Request) {
+	p.byCoin((*StratumServer).ResubmitBlock)(w, r)
+}
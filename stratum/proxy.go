@@ -0,0 +1,239 @@
+package stratum
+
+import (
+	"encoding/hex"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sammy007/monero-stratum/events"
+	"github.com/sammy007/monero-stratum/logging"
+	"github.com/sammy007/monero-stratum/proxy"
+	"github.com/sammy007/monero-stratum/util"
+)
+
+// Upstream pool job blob is already a hashing blob, local miners get a distinct
+// highest nonce byte each and are expected to roll the remaining 3 bytes (NiceHash mode).
+// Zero byte is left out, so at most 255 miners share upstream login.
+const maxProxySlots = 255
+
+// Upstream pools often send new job at the same height, shares for previous one
+// still in flight are accepted by them, so they stay valid here for at least this long
+const proxyJobOverlap = 10 * time.Second
+
+var (
+	errMalformedJobBlob = errors.New("Malformed job blob")
+	errReservedNonce    = errors.New("Highest nonce byte is reserved by pool")
+)
+
+func (s *StratumServer) initProxies() {
+	s.proxies = make([]*proxy.Client, len(s.config.Proxy.Pools))
+	for i, v := range s.config.Proxy.Pools {
+		client := proxy.NewClient(&v)
+		client.OnJob = s.onProxyJob
+		s.proxies[i] = client
+		s.upstreamLog.Infof("Upstream pool: %s => %s", client.Name, client.Url)
+	}
+	s.upstreamLog.Infof("Default upstream pool: %s => %s", s.proxyClient().Name, s.proxyClient().Url)
+}
+
+func (s *StratumServer) proxyClient() *proxy.Client {
+	i := atomic.LoadInt32(&s.upstream)
+	return s.proxies[i]
+}
+
+func (s *StratumServer) onProxyJob(c *proxy.Client) {
+	job := c.Job()
+	if c != s.proxyClient() || job == nil {
+		return
+	}
+	if s.installProxyJob(c, job) {
+		s.broadcastNewJobs()
+	}
+}
+
+func (s *StratumServer) installProxyJob(c *proxy.Client, job *proxy.Job) bool {
+	l := s.upstreamLog.With(logging.Fields{"upstream": c.Name, "height": job.Height})
+	buffer, err := s.proxyJobBlob(job)
+	if err != nil {
+		l.Errorf("Refusing job %s: %v", job.JobId, err)
+		return false
+	}
+	diff, ok := util.GetTargetDifficulty(job.Target)
+	if !ok {
+		l.Errorf("Malformed job target: %s", job.Target)
+		return false
+	}
+	newTemplate := &BlockTemplate{
+		createdAt:  util.MakeTimestamp(),
+		diffInt64:  diff.Int64(),
+		difficulty: diff,
+		height:     job.Height,
+		upstream:   c.Name,
+		buffer:     buffer,
+		proxyJobId: job.JobId,
+		coin:       s.coin,
+		proxy:      true,
+	}
+	t := s.currentBlockTemplate()
+	s.blockTemplate.Store(newTemplate)
+	if t != nil {
+		atomic.StoreInt64(&t.replacedAt, newTemplate.createdAt)
+	}
+	l.Infof("New job %s to mine, diff: %v", job.JobId, diff)
+	s.bus.Publish(s.coin.name, events.NewTemplate, &events.NewTemplateData{Upstream: c.Name, Height: job.Height, Difficulty: diff.Int64(), Reason: "new job"})
+	return true
+}
+
+func (s *StratumServer) checkProxies() {
+	alive := make([]bool, len(s.proxies))
+	var wg sync.WaitGroup
+
+	for i, v := range s.proxies {
+		wg.Add(1)
+		go func(i int, v *proxy.Client) {
+			defer wg.Done()
+			ok, err := v.Check()
+			if err != nil {
+				s.upstreamLog.With(logging.Fields{"upstream": v.Name}).Warnf("Upstream pool didn't pass check: %v", err)
+			}
+			alive[i] = ok
+		}(i, v)
+	}
+	wg.Wait()
+
+	jobs := make([]*proxy.Job, len(s.proxies))
+	for i, v := range s.proxies {
+		jobs[i] = v.Job()
+	}
+	current := int(atomic.LoadInt32(&s.upstream))
+	candidate := s.pickProxy(alive, jobs)
+	if candidate < 0 {
+		s.upstreamLog.With(logging.Fields{"upstream": s.proxies[current].Name}).Warnf("No eligible upstream pool, staying on current one")
+		return
+	}
+	if candidate == current {
+		return
+	}
+
+	c, prev := s.proxies[candidate], s.proxies[current]
+	s.upstreamLog.With(logging.Fields{"upstream": c.Name}).Infof("Switching upstream pool")
+	atomic.StoreInt32(&s.upstream, int32(candidate))
+	job := jobs[candidate]
+	switched := &events.UpstreamSwitchData{From: prev.Name, To: c.Name}
+	if job != nil {
+		switched.Height = job.Height
+	}
+	s.bus.Publish(s.coin.name, events.UpstreamSwitch, switched)
+	if job != nil && s.installProxyJob(c, job) {
+		s.broadcastNewJobs()
+	}
+}
+
+// shareOverlap is how long shares for replaced template at the same height stay valid
+func (s *StratumServer) shareOverlap(t *BlockTemplate) time.Duration {
+	if t.proxy && s.templateOverlap < proxyJobOverlap {
+		return proxyJobOverlap
+	}
+	return s.templateOverlap
+}
+
+// pickProxy returns pool to mine on, -1 if none is usable. Pools are ranked like daemon upstreams,
+// by priority and then job height, while healthy current pool is only left for one of higher priority.
+func (s *StratumServer) pickProxy(alive []bool, jobs []*proxy.Job) int {
+	bestHeight := int64(0)
+	for i, job := range jobs {
+		if alive[i] && job != nil && job.Height > bestHeight {
+			bestHeight = job.Height
+		}
+	}
+	// Pools don't have to report height, lag is only checked if they do
+	eligible := func(i int) bool {
+		if !alive[i] || jobs[i] == nil {
+			return false
+		}
+		if _, err := s.proxyJobBlob(jobs[i]); err != nil {
+			return false
+		}
+		return jobs[i].Height == 0 || bestHeight-jobs[i].Height <= s.config.UpstreamMaxHeightLag
+	}
+
+	candidate := -1
+	for i, v := range s.proxies {
+		if !eligible(i) {
+			continue
+		}
+		if candidate < 0 || v.Priority < s.proxies[candidate].Priority ||
+			v.Priority == s.proxies[candidate].Priority && jobs[i].Height > jobs[candidate].Height {
+			candidate = i
+		}
+	}
+	// Pools of the same priority take turns finding blocks, so height alone doesn't make pool hop
+	current := int(atomic.LoadInt32(&s.upstream))
+	if candidate >= 0 && candidate != current && eligible(current) && s.proxies[current].Priority <= s.proxies[candidate].Priority {
+		return current
+	}
+	return candidate
+}
+
+// proxyJobBlob decodes job blob of upstream pool. Pool that has fixed highest nonce byte itself,
+// as NiceHash does, leaves no room for local miner slots, so its jobs are refused.
+func (s *StratumServer) proxyJobBlob(job *proxy.Job) ([]byte, error) {
+	buffer, err := hex.DecodeString(job.Blob)
+	if err != nil || len(buffer) < s.coin.nonceOffset+4 {
+		return nil, errMalformedJobBlob
+	}
+	if buffer[s.coin.nonceOffset+3] != 0 {
+		return nil, errReservedNonce
+	}
+	return buffer, nil
+}
+
+// allocProxySlot reserves highest nonce byte for session, zero is never used since
+// miners only keep nonce byte of a job if it's non-zero
+func (s *StratumServer) allocProxySlot(cs *Session) bool {
+	s.proxySlotsMu.Lock()
+	defer s.proxySlotsMu.Unlock()
+	if cs.proxySlot != 0 {
+		return true
+	}
+	for i := 1; i <= maxProxySlots; i++ {
+		if !s.proxySlots[i] {
+			s.proxySlots[i] = true
+			cs.proxySlot = byte(i)
+			return true
+		}
+	}
+	return false
+}
+
+func (s *StratumServer) releaseProxySlot(cs *Session) {
+	s.proxySlotsMu.Lock()
+	defer s.proxySlotsMu.Unlock()
+	if cs.proxySlot != 0 {
+		s.proxySlots[cs.proxySlot] = false
+		cs.proxySlot = 0
+	}
+}
+
+func (b *BlockTemplate) proxyBlob(slot byte) []byte {
+	blob := make([]byte, len(b.buffer))
+	copy(blob, b.buffer)
+	blob[b.coin.nonceOffset+3] = slot
+	return blob
+}
+
+func (s *StratumServer) submitProxyShare(t *BlockTemplate, m *Miner, cs *Session, nonce string, hashBytes []byte) {
+	c := s.proxyClient()
+	if c.Name != t.upstream {
+		cs.logger(s.shareLog, m).With(logging.Fields{"upstream": t.upstream}).Infof("Dropping share for job of previous pool")
+		return
+	}
+	err := c.Submit(t.proxyJobId, nonce, hex.EncodeToString(hashBytes))
+	if err != nil {
+		cs.logger(s.shareLog, m).With(logging.Fields{"upstream": c.Name}).Warnf("Share rejected by pool: %v", err)
+		return
+	}
+	cs.logger(s.shareLog, m).With(logging.Fields{"upstream": c.Name}).Debugf("Share accepted by pool")
+}
@@ -0,0 +1,137 @@
+package stratum
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sammy007/monero-stratum/pool"
+	"github.com/sammy007/monero-stratum/proxy"
+	"github.com/sammy007/monero-stratum/util"
+)
+
+func TestAllocProxySlot(t *testing.T) {
+	s := newTestServer(&pool.Config{})
+	sessions := make([]*Session, maxProxySlots)
+	seen := make(map[byte]bool)
+	for i := range sessions {
+		sessions[i] = &Session{}
+		if !s.allocProxySlot(sessions[i]) {
+			t.Fatalf("#%d: no slot allocated", i)
+		}
+		if slot := sessions[i].proxySlot; slot == 0 || seen[slot] {
+			t.Errorf("#%d: got slot %v", i, slot)
+		}
+		seen[sessions[i].proxySlot] = true
+	}
+	if cs := (&Session{}); s.allocProxySlot(cs) {
+		t.Error("Allocated slot beyond limit")
+	}
+
+	slot := sessions[10].proxySlot
+	// Login again keeps slot of session
+	if !s.allocProxySlot(sessions[10]) || sessions[10].proxySlot != slot {
+		t.Error("Session slot changed")
+	}
+	s.releaseProxySlot(sessions[10])
+	if cs := (&Session{}); !s.allocProxySlot(cs) || cs.proxySlot != slot {
+		t.Error("Released slot is not reused")
+	}
+}
+
+func TestProxyJobBlob(t *testing.T) {
+	s := newTestServer(&pool.Config{})
+	nonce := s.coin.nonceOffset * 2
+	blob := strings.Repeat("00", s.coin.nonceOffset+4+32)
+	tests := []struct {
+		blob string
+		err  error
+	}{
+		{blob, nil},
+		{blob[:nonce] + "aabbcc00" + blob[nonce+8:], nil},
+		{blob[:nonce] + "000000ff" + blob[nonce+8:], errReservedNonce},
+		{blob[:nonce], errMalformedJobBlob},
+		{"zz" + blob[2:], errMalformedJobBlob},
+	}
+	for i, tt := range tests {
+		if _, err := s.proxyJobBlob(&proxy.Job{Blob: tt.blob}); err != tt.err {
+			t.Errorf("#%d: expected %v, got %v", i, tt.err, err)
+		}
+	}
+}
+
+func TestPickProxy(t *testing.T) {
+	s := newTestServer(&pool.Config{UpstreamMaxHeightLag: 2})
+	for _, priority := range []int{0, 0, 1} {
+		s.proxies = append(s.proxies, proxy.NewClient(&pool.ProxyPool{Priority: priority}))
+	}
+	blob := strings.Repeat("00", s.coin.nonceOffset+4)
+	reserved := blob[:len(blob)-2] + "01"
+	job := func(height int64) *proxy.Job { return &proxy.Job{Blob: blob, Height: height} }
+
+	tests := []struct {
+		current  int32
+		alive    []bool
+		jobs     []*proxy.Job
+		expected int
+	}{
+		{0, []bool{true, true, true}, []*proxy.Job{job(10), job(10), job(10)}, 0},
+		// Pool of the same priority ahead by a block doesn't make pool hop
+		{0, []bool{true, true, true}, []*proxy.Job{job(10), job(11), job(11)}, 0},
+		{0, []bool{false, true, true}, []*proxy.Job{job(10), job(10), job(10)}, 1},
+		{2, []bool{true, true, true}, []*proxy.Job{job(10), job(10), job(10)}, 0},
+		{2, []bool{true, true, true}, []*proxy.Job{job(10), job(11), job(10)}, 1},
+		// Current pool lags behind too much
+		{0, []bool{true, true, true}, []*proxy.Job{job(10), job(13), job(13)}, 1},
+		// Pool without height is never taken as lagging
+		{0, []bool{true, true, true}, []*proxy.Job{job(0), job(13), job(13)}, 0},
+		{0, []bool{false, true, true}, []*proxy.Job{job(10), {Blob: reserved, Height: 10}, job(10)}, 2},
+		{0, []bool{true, true, false}, []*proxy.Job{nil, nil, job(10)}, -1},
+	}
+	for i, tt := range tests {
+		s.upstream = tt.current
+		if candidate := s.pickProxy(tt.alive, tt.jobs); candidate != tt.expected {
+			t.Errorf("#%d: expected %v, got %v", i, tt.expected, candidate)
+		}
+	}
+}
+
+func TestProxyShareSlot(t *testing.T) {
+	s := newTestServer(&pool.Config{})
+	tpl := &BlockTemplate{buffer: make([]byte, s.coin.nonceOffset+4), coin: s.coin, proxy: true}
+	cs := &Session{ip: "127.0.0.1", endpoint: &Endpoint{config: &pool.Port{}}, proxySlot: 5}
+	m := NewMiner("0", "", "A", false)
+	// Nonce is little endian, highest byte comes last
+	if err := m.processShare(s, cs, &Job{}, tpl, "00000006", "", &shareRecord{}); err == nil {
+		t.Error("Nonce of another slot is accepted")
+	}
+	if m.invalidShares != 1 {
+		t.Errorf("Expected 1 invalid share, got %v", m.invalidShares)
+	}
+	if blob := tpl.proxyBlob(cs.proxySlot); blob[s.coin.nonceOffset+3] != 5 {
+		t.Error("Slot is not set in blob")
+	}
+}
+
+func TestShareOverlap(t *testing.T) {
+	s := newTestServer(&pool.Config{})
+	replacedAt := util.MakeTimestamp() - 1000
+	tests := []struct {
+		proxy   bool
+		overlap time.Duration
+		expired bool
+	}{
+		// New upstream job at the same height doesn't make shares of previous one stale right away
+		{true, 0, false},
+		{false, 0, true},
+		{false, 5 * time.Second, false},
+		{true, time.Millisecond, false},
+	}
+	for i, tt := range tests {
+		s.templateOverlap = tt.overlap
+		tpl := &BlockTemplate{proxy: tt.proxy, replacedAt: replacedAt}
+		if expired := tpl.expired(s.shareOverlap(tpl)); expired != tt.expired {
+			t.Errorf("#%d: expected expired %v, got %v", i, tt.expired, expired)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package stratum
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sammy007/monero-stratum/logging"
+	"github.com/sammy007/monero-stratum/pool"
+	"github.com/sammy007/monero-stratum/util"
+)
+
+// workerIndex counts workers of every address to cap them, miners map itself is keyed by worker id
+type workerIndex struct {
+	purged       int64
+	refused      int64
+	maxPerAddr   int
+	purgeOffline time.Duration
+	sync.Mutex
+	perAddress map[string]int
+	log        *logging.Logger
+}
+
+func newWorkerIndex(cfg *pool.Stratum, log *logging.Logger) *workerIndex {
+	w := &workerIndex{maxPerAddr: cfg.MaxWorkersPerAddress, perAddress: make(map[string]int), log: log}
+	w.purgeOffline, _ = time.ParseDuration(cfg.PurgeOffline)
+	return w
+}
+
+// registerMiner returns worker of id and address, creating it unless the address has too many workers already.
+// Concurrent logins of new worker get the same miner, heartbeat keeps it from being purged before session attaches.
+func (s *StratumServer) registerMiner(id, ip, address string, solo bool) (*Miner, bool) {
+	key := minerKey(id, address, solo)
+	s.workers.Lock()
+	defer s.workers.Unlock()
+	if miner, ok := s.miners.Get(key); ok {
+		miner.heartbeat()
+		return miner, true
+	}
+	if s.workers.maxPerAddr > 0 && s.workers.perAddress[address] >= s.workers.maxPerAddr {
+		atomic.AddInt64(&s.workers.refused, 1)
+		return nil, false
+	}
+	miner := NewMiner(id, ip, address, solo)
+	miner.heartbeat()
+	s.miners.Set(key, miner)
+	s.workers.perAddress[address]++
+	return miner, true
+}
+
+func (s *StratumServer) removeMiner(miner *Miner) {
+	s.miners.Remove(miner.key)
+	if s.workers.perAddress[miner.address] <= 1 {
+		delete(s.workers.perAddress, miner.address)
+	} else {
+		s.workers.perAddress[miner.address]--
+	}
+}
+
+// purgeMiners evicts workers offline longer than purge period and drops share buckets out of 24h window of the rest
+func (s *StratumServer) purgeMiners() {
+	now := util.MakeTimestamp()
+	deadline := now - int64(s.workers.purgeOffline/time.Millisecond)
+	var stale []*Miner
+
+	for m := range s.miners.IterBuffered() {
+		// Idle miner stays connected until socket timeout, its worker is kept till then
+		if m.Val.getLastBeat() < deadline && m.Val.sessionCount() == 0 {
+			stale = append(stale, m.Val)
+		} else {
+			m.Val.pruneShares(now / 1000)
+		}
+	}
+
+	s.workers.Lock()
+	defer s.workers.Unlock()
+	for _, m := range stale {
+		// Worker could have logged in again since the check
+		if current, ok := s.miners.Get(m.key); !ok || current != m || m.getLastBeat() >= deadline || m.sessionCount() > 0 {
+			continue
+		}
+		s.removeMiner(m)
+		atomic.AddInt64(&s.workers.purged, 1)
+		// There is no stats storage, so final stats of worker are only logged
+		s.workers.log.With(logging.Fields{"worker": m.id, "address": m.address}).Infof(
+			"Purged offline worker, last beat %v, valid %v, stale %v, invalid %v shares",
+			time.Unix(0, m.getLastBeat()*int64(time.Millisecond)).Format(time.RFC3339),
+			atomic.LoadInt64(&m.validShares), atomic.LoadInt64(&m.staleShares), atomic.LoadInt64(&m.invalidShares))
+	}
+}
+
+// workerStats reports purge counters and sizes of maps that grow with miners
+func (s *StratumServer) workerStats() map[string]interface{} {
+	buckets := 0
+	for m := range s.miners.IterBuffered() {
+		m.Val.RLock()
+		buckets += len(m.Val.shares)
+		m.Val.RUnlock()
+	}
+	maps := map[string]interface{}{
+		"miners":       s.miners.Count(),
+		"shareBuckets": buckets,
+	}
+	s.workers.Lock()
+	maps["addresses"] = len(s.workers.perAddress)
+	s.workers.Unlock()
+	s.sessionsMu.RLock()
+	maps["sessions"] = len(s.sessions)
+	s.sessionsMu.RUnlock()
+	s.bansMu.RLock()
+	maps["bans"] = len(s.bans)
+	s.bansMu.RUnlock()
+	s.soloMu.Lock()
+	maps["soloTemplates"] = len(s.soloTemplates)
+	s.soloMu.Unlock()
+	s.blocksMu.RLock()
+	maps["blocks"] = len(s.blockStats)
+	s.blocksMu.RUnlock()
+
+	return map[string]interface{}{
+		"maps":    maps,
+		"purged":  atomic.LoadInt64(&s.workers.purged),
+		"refused": atomic.LoadInt64(&s.workers.refused),
+	}
+}
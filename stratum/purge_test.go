@@ -0,0 +1,103 @@
+package stratum
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/sammy007/monero-stratum/logging"
+	"github.com/sammy007/monero-stratum/pool"
+	"github.com/sammy007/monero-stratum/util"
+)
+
+func newTestServer(cfg *pool.Config) *StratumServer {
+	logging.SetOutput(ioutil.Discard)
+	s := &StratumServer{
+		config:        cfg,
+		coin:          newCoinProfile(&cfg.Coin),
+		miners:        NewMinersMap(),
+		sessions:      make(map[*Session]struct{}),
+		bans:          make(map[string]int64),
+		soloTemplates: make(map[string]*soloEntry),
+		blockStats:    make(map[int64]blockEntry),
+		admission:     newAdmission(&cfg.Stratum),
+		log:           logging.New("stratum"),
+		shareLog:      logging.New("shares"),
+		upstreamLog:   logging.New("upstream"),
+	}
+	s.workers = newWorkerIndex(&cfg.Stratum, s.log)
+	return s
+}
+
+func TestRegisterMiner(t *testing.T) {
+	s := newTestServer(&pool.Config{Stratum: pool.Stratum{MaxWorkersPerAddress: 2}})
+	tests := []struct {
+		id      string
+		address string
+		solo    bool
+		ok      bool
+		same    int
+	}{
+		{"0", "A", false, true, -1},
+		{"0", "B", false, true, -1},
+		{"0", "A", false, true, 0},
+		{"1", "A", false, true, -1},
+		// Reused worker name of another address counts against address logging in
+		{"1", "B", false, true, -1},
+		{"2", "A", false, false, -1},
+		{"C.0", "C", true, true, -1},
+		// Pool login can't take solo worker over
+		{"C.0", "x", false, true, -1},
+	}
+	var miners []*Miner
+	for i, tt := range tests {
+		m, ok := s.registerMiner(tt.id, "", tt.address, tt.solo)
+		miners = append(miners, m)
+		if ok != tt.ok {
+			t.Errorf("#%d: expected %v, got %v", i, tt.ok, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if m.address != tt.address || m.solo != tt.solo {
+			t.Errorf("#%d: got miner of %s", i, m.address)
+		}
+		for j := 0; j < i; j++ {
+			if same := miners[j] == m; same != (j == tt.same) {
+				t.Errorf("#%d: miner is shared with #%d", i, j)
+			}
+		}
+	}
+	if s.workers.perAddress["A"] != 2 || s.workers.perAddress["B"] != 2 || s.workers.refused != 1 {
+		t.Errorf("Wrong worker counts %v, refused %v", s.workers.perAddress, s.workers.refused)
+	}
+}
+
+func TestPurgeMiners(t *testing.T) {
+	s := newTestServer(&pool.Config{Stratum: pool.Stratum{PurgeOffline: "1h"}})
+	hourAgo := util.MakeTimestamp() - 3600*1000
+	online, _ := s.registerMiner("online", "", "A", false)
+	offline, _ := s.registerMiner("offline", "", "A", false)
+	idle, _ := s.registerMiner("idle", "", "A", false)
+	offline.lastBeat = hourAgo - 1
+	idle.lastBeat = hourAgo - 1
+	// Connected miner is kept until its session times out
+	idle.sessions[&Session{}] = struct{}{}
+	online.shares[1] = 100
+
+	s.purgeMiners()
+	for _, m := range []*Miner{online, idle} {
+		if _, ok := s.miners.Get(m.key); !ok {
+			t.Errorf("Worker %s is purged", m.id)
+		}
+	}
+	if _, ok := s.miners.Get(offline.key); ok {
+		t.Error("Offline worker is not purged")
+	}
+	if len(online.shares) != 0 {
+		t.Error("Old share buckets are kept")
+	}
+	if s.workers.perAddress["A"] != 2 || s.workers.purged != 1 {
+		t.Errorf("Wrong worker counts %v, purged %v", s.workers.perAddress, s.workers.purged)
+	}
+}
@@ -0,0 +1,91 @@
+package stratum
+
+import (
+	"net"
+	"sort"
+	"sync/atomic"
+)
+
+// countingConn counts traffic of session for stats
+type countingConn struct {
+	read    int64
+	written int64
+	net.Conn
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.read, int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.written, int64(n))
+	return n, err
+}
+
+// attach links session to worker it has logged in as, session may switch worker by logging in again.
+// Link is only changed by client goroutine of session, so it needs no lock of its own.
+func (cs *Session) attach(m *Miner) {
+	if cs.miner == m {
+		return
+	}
+	cs.detach()
+	m.Lock()
+	m.sessions[cs] = struct{}{}
+	m.Unlock()
+	cs.miner = m
+}
+
+func (cs *Session) detach() {
+	if cs.miner == nil {
+		return
+	}
+	cs.miner.Lock()
+	delete(cs.miner.sessions, cs)
+	cs.miner.Unlock()
+	cs.miner = nil
+}
+
+// Session lock is held while writing to miner, so stats only read fields that are atomic or never change
+func (cs *Session) stats(redact bool) map[string]interface{} {
+	stats := map[string]interface{}{
+		"port":        cs.endpoint.config.Port,
+		"connectedAt": cs.connectedAt,
+		"difficulty":  atomic.LoadInt64(&cs.difficulty),
+		"jobs":        atomic.LoadInt32(&cs.jobs),
+		"bytesIn":     atomic.LoadInt64(&cs.conn.read),
+		"bytesOut":    atomic.LoadInt64(&cs.conn.written),
+	}
+	if cs.endpoint.unix {
+		stats["socket"] = cs.endpoint.config.Socket
+	}
+	if !redact {
+		stats["ip"] = cs.ip
+	}
+	return stats
+}
+
+// sessionStats lists live sessions of worker, oldest first
+func (m *Miner) sessionStats(redact bool) []map[string]interface{} {
+	m.RLock()
+	sessions := make([]*Session, 0, len(m.sessions))
+	for cs := range m.sessions {
+		sessions = append(sessions, cs)
+	}
+	m.RUnlock()
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].connectedAt < sessions[j].connectedAt })
+
+	stats := make([]map[string]interface{}, len(sessions))
+	for i, cs := range sessions {
+		stats[i] = cs.stats(redact)
+	}
+	return stats
+}
+
+func (m *Miner) sessionCount() int {
+	m.RLock()
+	defer m.RUnlock()
+	return len(m.sessions)
+}
@@ -0,0 +1,74 @@
+package stratum
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/sammy007/monero-stratum/logging"
+)
+
+// Cached block template built for solo miner's own address
+type soloEntry struct {
+	sync.Mutex
+	template *BlockTemplate
+}
+
+// sessionTemplate returns template to build session jobs from, solo sessions get one for their own address
+func (s *StratumServer) sessionTemplate(cs *Session) *BlockTemplate {
+	if len(cs.soloAddress) > 0 {
+		return s.soloTemplate(cs.soloAddress)
+	}
+	return s.currentBlockTemplate()
+}
+
+// soloTemplate returns cached template for address, fetching new one once pool template has been replaced
+func (s *StratumServer) soloTemplate(address string) *BlockTemplate {
+	current := s.currentBlockTemplate()
+	if current == nil {
+		return nil
+	}
+
+	s.soloMu.Lock()
+	entry, ok := s.soloTemplates[address]
+	if !ok {
+		entry = &soloEntry{}
+		s.soloTemplates[address] = entry
+	}
+	s.soloMu.Unlock()
+
+	entry.Lock()
+	defer entry.Unlock()
+	t := entry.template
+	if t != nil && t.prevHash == current.prevHash && t.height == current.height && t.createdAt >= current.createdAt {
+		return t
+	}
+
+	r := s.rpc()
+	reply, err := r.GetBlockTemplate(8, address)
+	if err != nil {
+		s.upstreamLog.With(logging.Fields{"upstream": r.Name}).Errorf("Error while refreshing solo block template for %s: %v", address, err)
+		if t != nil && t.height == current.height {
+			return t
+		}
+		return nil
+	}
+	newTemplate := newBlockTemplate(r, reply, s.coin)
+	entry.template = newTemplate
+	if t != nil {
+		atomic.StoreInt64(&t.replacedAt, newTemplate.createdAt)
+	}
+	return newTemplate
+}
+
+// pruneSoloTemplates drops cached templates left behind by the chain, active solo sessions will fetch new ones
+func (s *StratumServer) pruneSoloTemplates(height int64) {
+	s.soloMu.Lock()
+	defer s.soloMu.Unlock()
+	for address, entry := range s.soloTemplates {
+		entry.Lock()
+		if entry.template == nil || entry.template.height < height {
+			delete(s.soloTemplates, address)
+		}
+		entry.Unlock()
+	}
+}
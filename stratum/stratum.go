@@ -6,44 +6,84 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"math/big"
 	"net"
+	"os"
+	"runtime"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/sammy007/monero-stratum/events"
+	"github.com/sammy007/monero-stratum/logging"
 	"github.com/sammy007/monero-stratum/pool"
+	"github.com/sammy007/monero-stratum/proxy"
 	"github.com/sammy007/monero-stratum/rpc"
 	"github.com/sammy007/monero-stratum/util"
 )
 
 type StratumServer struct {
-	luckWindow       int64
-	luckLargeWindow  int64
-	roundShares      int64
-	blockStats       map[int64]blockEntry
-	config           *pool.Config
-	miners           MinersMap
-	blockTemplate    atomic.Value
-	upstream         int32
-	upstreams        []*rpc.RPCClient
-	timeout          time.Duration
-	estimationWindow time.Duration
-	blocksMu         sync.RWMutex
-	sessionsMu       sync.RWMutex
-	sessions         map[*Session]struct{}
+	luckWindow          int64
+	luckLargeWindow     int64
+	roundShares         int64
+	blockStats          map[int64]blockEntry
+	config              *pool.Config
+	miners              MinersMap
+	workers             *workerIndex
+	blockTemplate       atomic.Value
+	upstream            int32
+	coin                *coinProfile
+	validator           *shareValidator
+	verifier            *daemonVerifier
+	trustThreshold      int64
+	checkRatio          float64
+	banTimeout          time.Duration
+	bansMu              sync.RWMutex
+	bans                map[string]int64
+	upstreams           []*rpc.RPCClient
+	proxies             []*proxy.Client
+	proxySlotsMu        sync.Mutex
+	proxySlots          [maxProxySlots + 1]bool
+	timeout             time.Duration
+	estimationWindow    time.Duration
+	retryBackoff        time.Duration
+	templateRefreshIntv time.Duration
+	templateOverlap     time.Duration
+	journal             *blockJournal
+	shareAudit          *shareAudit
+	admission           *admission
+	loginTimeout        time.Duration
+	agentPolicy         *agentPolicy
+	alerts              *alertEngine
+	bus                 *events.Bus
+	blocksMu            sync.RWMutex
+	sessionsMu          sync.RWMutex
+	sessions            map[*Session]struct{}
+	soloMu              sync.Mutex
+	soloTemplates       map[string]*soloEntry
+	log                 *logging.Logger
+	shareLog            *logging.Logger
+	blockLog            *logging.Logger
+	upstreamLog         *logging.Logger
 }
 
 type blockEntry struct {
-	height   int64
-	variance float64
-	hash     string
+	height    int64
+	variance  float64
+	hash      string
+	upstreams []string
+	address   string
+	rejected  bool
 }
 
 type Endpoint struct {
 	jobSequence uint64
+	conns       int32
 	config      *pool.Port
+	unix        bool
+	allow       networks
+	deny        networks
 	difficulty  *big.Int
 	instanceId  []byte
 	extraNonce  uint32
@@ -51,39 +91,65 @@ type Endpoint struct {
 }
 
 type Session struct {
-	lastBlockHeight int64
+	connectedAt int64
+	difficulty  int64
+	jobs        int32
 	sync.Mutex
-	conn            *net.TCPConn
-	enc             *json.Encoder
-	ip              string
-	endpoint        *Endpoint
-	validJobs       []*Job
+	lastTemplate *BlockTemplate
+	conn         *countingConn
+	miner        *Miner
+	enc          *json.Encoder
+	ip           string
+	soloAddress  string
+	address      string
+	agent        atomic.Value
+	proxySlot    byte
+	endpoint     *Endpoint
+	validJobs    []*Job
 }
 
 const (
 	MaxReqSize = 10 * 1024
 )
 
-func NewStratum(cfg *pool.Config) *StratumServer {
-	stratum := &StratumServer{config: cfg, blockStats: make(map[int64]blockEntry)}
-
-	stratum.upstreams = make([]*rpc.RPCClient, len(cfg.Upstream))
-	for i, v := range cfg.Upstream {
-		client, err := rpc.NewRPCClient(&v)
-		if err != nil {
-			log.Fatal(err)
-		} else {
-			stratum.upstreams[i] = client
-			log.Printf("Upstream: %s => %s", client.Name, client.Url)
+func NewStratum(cfg *pool.Config, bus *events.Bus) *StratumServer {
+	stratum := &StratumServer{config: cfg, bus: bus, blockStats: make(map[int64]blockEntry)}
+	stratum.coin = newCoinProfile(&cfg.Coin)
+	coinField := logging.Fields{"coin": stratum.coin.name}
+	stratum.log = logging.New("stratum").With(coinField)
+	stratum.shareLog = logging.New("shares").With(coinField)
+	stratum.blockLog = logging.New("blocks").With(coinField)
+	stratum.upstreamLog = logging.New("upstream").With(coinField)
+	stratum.log.Infof("Coin profile %s, nonce offset %v, address prefixes %v", stratum.coin.name, stratum.coin.nonceOffset, cfg.Coin.AddressPrefixes)
+
+	if cfg.Proxy.Enabled {
+		stratum.initProxies()
+	} else {
+		stratum.upstreams = make([]*rpc.RPCClient, len(cfg.Upstream))
+		for i, v := range cfg.Upstream {
+			client, err := rpc.NewRPCClient(&v)
+			if err != nil {
+				stratum.upstreamLog.Fatalf("%v", err)
+			} else {
+				stratum.upstreams[i] = client
+				stratum.upstreamLog.Infof("Upstream: %s => %s", client.Name, client.Url)
+			}
 		}
+		stratum.upstreamLog.Infof("Default upstream: %s => %s", stratum.rpc().Name, stratum.rpc().Url)
 	}
-	log.Printf("Default upstream: %s => %s", stratum.rpc().Name, stratum.rpc().Url)
 
 	stratum.miners = NewMinersMap()
+	stratum.workers = newWorkerIndex(&cfg.Stratum, stratum.log)
 	stratum.sessions = make(map[*Session]struct{})
+	stratum.soloTemplates = make(map[string]*soloEntry)
 
 	timeout, _ := time.ParseDuration(cfg.Stratum.Timeout)
 	stratum.timeout = timeout
+	stratum.loginTimeout, _ = time.ParseDuration(cfg.Stratum.LoginTimeout)
+	if stratum.loginTimeout <= 0 {
+		stratum.loginTimeout = timeout
+	}
+	stratum.admission = newAdmission(&cfg.Stratum)
 
 	estimationWindow, _ := time.ParseDuration(cfg.EstimationWindow)
 	stratum.estimationWindow = estimationWindow
@@ -93,15 +159,80 @@ func NewStratum(cfg *pool.Config) *StratumServer {
 	luckLargeWindow, _ := time.ParseDuration(cfg.LargeLuckWindow)
 	stratum.luckLargeWindow = int64(luckLargeWindow / time.Millisecond)
 
-	refreshIntv, _ := time.ParseDuration(cfg.BlockRefreshInterval)
-	refreshTimer := time.NewTimer(refreshIntv)
-	log.Printf("Set block refresh every %v", refreshIntv)
+	workers := cfg.Threads
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	stratum.validator = newShareValidator(workers, cfg.ShareValidation.QueueSize, cfg.ShareValidation.Policy)
+	stratum.shareLog.Infof("Share validation with %v workers, queue size %v, %s policy", workers, cfg.ShareValidation.QueueSize, stratum.validator.policy)
+
+	if cfg.ShareValidation.Verifier == daemonVerifierName && !cfg.Proxy.Enabled {
+		stratum.verifier = newDaemonVerifier(cfg.ShareValidation.RateLimit)
+		stratum.shareLog.Infof("Verifying shares with daemon calc_pow, rate limit %v/s", cfg.ShareValidation.RateLimit)
+	}
+
+	stratum.trustThreshold = cfg.ShareValidation.TrustThreshold
+	if stratum.trustThreshold <= 0 {
+		stratum.trustThreshold = defaultTrustThreshold
+	}
+	stratum.checkRatio = checkRatio(&cfg.ShareValidation)
+	banTimeout, _ := time.ParseDuration(cfg.ShareValidation.BanTimeout)
+	stratum.banTimeout = banTimeout
+	stratum.bans = make(map[string]int64)
+
+	stratum.journal = newBlockJournal(cfg.BlockSubmit.Journal, stratum.blockLog)
+	stratum.shareAudit = newShareAudit(&cfg.ShareLog, stratum.shareLog)
+	stratum.agentPolicy = newAgentPolicy(cfg.AgentPolicy)
+	retryBackoff, _ := time.ParseDuration(cfg.BlockSubmit.RetryBackoff)
+	stratum.retryBackoff = retryBackoff
+
+	templateRefreshIntv, _ := time.ParseDuration(cfg.TemplateRefresh.Interval)
+	stratum.templateRefreshIntv = templateRefreshIntv
+	templateOverlap, _ := time.ParseDuration(cfg.TemplateRefresh.Overlap)
+	stratum.templateOverlap = templateOverlap
+
+	if stratum.workers.purgeOffline > 0 {
+		purgeIntv, _ := time.ParseDuration(cfg.Stratum.PurgeInterval)
+		stratum.log.Infof("Purging workers offline for %v every %v", stratum.workers.purgeOffline, purgeIntv)
+		go func() {
+			for range time.Tick(purgeIntv) {
+				stratum.purgeMiners()
+			}
+		}()
+	}
+
+	if cfg.Alerts.Enabled {
+		stratum.alerts = newAlertEngine(&cfg.Alerts, stratum.coin.name, logging.New("alerts").With(coinField))
+		alertIntv, _ := time.ParseDuration(cfg.Alerts.Interval)
+		go func() {
+			for range time.Tick(alertIntv) {
+				stratum.checkAlerts()
+			}
+		}()
+	}
 
 	checkIntv, _ := time.ParseDuration(cfg.UpstreamCheckInterval)
 	checkTimer := time.NewTimer(checkIntv)
 
-	infoIntv, _ := time.ParseDuration(cfg.UpstreamCheckInterval)
-	infoTimer := time.NewTimer(infoIntv)
+	// Upstream pool pushes jobs on its own, only keep connection alive
+	if cfg.Proxy.Enabled {
+		go stratum.checkProxies()
+
+		go func() {
+			for {
+				select {
+				case <-checkTimer.C:
+					stratum.checkProxies()
+					checkTimer.Reset(checkIntv)
+				}
+			}
+		}()
+		return stratum
+	}
+
+	refreshIntv, _ := time.ParseDuration(cfg.BlockRefreshInterval)
+	refreshTimer := time.NewTimer(refreshIntv)
+	stratum.log.Infof("Set block refresh every %v", refreshIntv)
 
 	// Init block template
 	go stratum.refreshBlockTemplate(false)
@@ -126,41 +257,15 @@ func NewStratum(cfg *pool.Config) *StratumServer {
 		}
 	}()
 
-	go func() {
-		for {
-			select {
-			case <-infoTimer.C:
-				poll := func(v *rpc.RPCClient) {
-					_, err := v.UpdateInfo()
-					if err != nil {
-						log.Printf("Unable to update info on upstream %s: %v", v.Name, err)
-					}
-				}
-				current := stratum.rpc()
-				poll(current)
-
-				// Async rpc call to not block on rpc timeout, ignoring current
-				go func() {
-					for _, v := range stratum.upstreams {
-						if v != current {
-							poll(v)
-						}
-					}
-				}()
-				infoTimer.Reset(infoIntv)
-			}
-		}
-	}()
-
 	return stratum
 }
 
 func NewEndpoint(cfg *pool.Port) *Endpoint {
-	e := &Endpoint{config: cfg}
+	e := &Endpoint{config: cfg, unix: len(cfg.Socket) > 0, allow: parseNetworks(cfg.Allow), deny: parseNetworks(cfg.Deny)}
 	e.instanceId = make([]byte, 4)
 	_, err := rand.Read(e.instanceId)
 	if err != nil {
-		log.Fatalf("Can't seed with random bytes: %v", err)
+		logging.New("stratum").Fatalf("Can't seed with random bytes: %v", err)
 	}
 	e.targetHex = util.GetTargetHex(e.config.Difficulty)
 	e.difficulty = big.NewInt(e.config.Difficulty)
@@ -179,53 +284,116 @@ func (s *StratumServer) Listen() {
 }
 
 func (e *Endpoint) Listen(s *StratumServer) {
-	bindAddr := fmt.Sprintf("%s:%d", e.config.Host, e.config.Port)
-	addr, err := net.ResolveTCPAddr("tcp", bindAddr)
-	if err != nil {
-		log.Fatalf("Error: %v", err)
-	}
-	server, err := net.ListenTCP("tcp", addr)
-	if err != nil {
-		log.Fatalf("Error: %v", err)
-	}
+	server, l := e.listen(s)
 	defer server.Close()
 
-	log.Printf("Stratum listening on %s", bindAddr)
-	accept := make(chan int, e.config.MaxConn)
-	n := 0
-
 	for {
-		conn, err := server.AcceptTCP()
+		conn, err := server.Accept()
 		if err != nil {
 			continue
 		}
-		conn.SetKeepAlive(true)
-		ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
-		cs := &Session{conn: conn, ip: ip, enc: json.NewEncoder(conn), endpoint: e}
-		n += 1
+		// Refused connections are closed right away, so listener never stalls
+		var addr net.IP
+		ip := "unix:" + e.config.Socket
+		if remote, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+			addr = remote.IP
+			ip = addr.String()
+		}
+		if reason, ok := s.admit(e, addr, ip); !ok {
+			s.admission.refuse(reason)
+			l.With(logging.Fields{"ip": ip, "reason": reason}).Debugf("Connection refused")
+			if tcp, ok := conn.(*net.TCPConn); ok {
+				tcp.SetLinger(0)
+			}
+			conn.Close()
+			continue
+		}
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			tcp.SetKeepAlive(true)
+		}
+		counted := &countingConn{Conn: conn}
+		cs := &Session{conn: counted, ip: ip, enc: json.NewEncoder(counted), endpoint: e, connectedAt: util.MakeTimestamp()}
 
-		accept <- n
 		go func() {
 			s.handleClient(cs, e)
-			<-accept
+			atomic.AddInt32(&e.conns, -1)
+			if !e.unix {
+				s.admission.releaseIP(ip)
+			}
 		}()
 	}
 }
 
+// listen binds unix socket or TCP address, IPv6 wildcard host "::" or empty host accepts both IPv4 and IPv6
+func (e *Endpoint) listen(s *StratumServer) (net.Listener, *logging.Logger) {
+	if e.unix {
+		l := s.log.With(logging.Fields{"socket": e.config.Socket})
+		// Socket file left by previous run would fail bind, anything else at that path is kept
+		if fi, err := os.Lstat(e.config.Socket); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(e.config.Socket)
+		}
+		server, err := net.Listen("unix", e.config.Socket)
+		if err != nil {
+			l.Fatalf("Error: %v", err)
+		}
+		l.Infof("Stratum listening on unix socket %s", e.config.Socket)
+		return server, l
+	}
+	bindAddr := net.JoinHostPort(e.config.Host, strconv.Itoa(e.config.Port))
+	l := s.log.With(logging.Fields{"port": e.config.Port})
+	server, err := net.Listen("tcp", bindAddr)
+	if err != nil {
+		l.Fatalf("Error: %v", err)
+	}
+	l.Infof("Stratum listening on %s", bindAddr)
+	return server, l
+}
+
+// admit checks connection against port lists, bans and connection caps, it takes connection slots if admitted.
+// Unix socket clients are local, so only port connection cap applies to them.
+func (s *StratumServer) admit(e *Endpoint, addr net.IP, ip string) (string, bool) {
+	if e.unix {
+		if atomic.AddInt32(&e.conns, 1) > int32(e.config.MaxConn) {
+			atomic.AddInt32(&e.conns, -1)
+			return refusedMaxConn, false
+		}
+		return "", true
+	}
+	if !e.permitted(addr) {
+		return refusedDenied, false
+	}
+	if s.isBanned(ip) {
+		return refusedBanned, false
+	}
+	if atomic.AddInt32(&e.conns, 1) > int32(e.config.MaxConn) {
+		atomic.AddInt32(&e.conns, -1)
+		return refusedMaxConn, false
+	}
+	if !s.admission.acquireIP(ip) {
+		atomic.AddInt32(&e.conns, -1)
+		return refusedPerIP, false
+	}
+	return "", true
+}
+
 func (s *StratumServer) handleClient(cs *Session, e *Endpoint) {
 	connbuff := bufio.NewReaderSize(cs.conn, MaxReqSize)
-	s.setDeadline(cs.conn)
+	s.setSessionDeadline(cs)
 
 	for {
 		data, isPrefix, err := connbuff.ReadLine()
 		if isPrefix {
-			log.Println("Socket flood detected from", cs.ip)
+			cs.logger(s.log, nil).Warnf("Socket flood detected")
 			break
 		} else if err == io.EOF {
-			log.Println("Client disconnected", cs.ip)
+			cs.logger(s.log, nil).Debugf("Client disconnected")
+			break
+		} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() && len(cs.address) == 0 {
+			s.admission.refuse(refusedLogin)
+			cs.logger(s.log, nil).Debugf("Client didn't log in in time")
 			break
 		} else if err != nil {
-			log.Println("Error reading:", err)
+			cs.logger(s.log, nil).Warnf("Error reading: %v", err)
 			break
 		}
 
@@ -235,10 +403,10 @@ func (s *StratumServer) handleClient(cs *Session, e *Endpoint) {
 			var req JSONRpcReq
 			err = json.Unmarshal(data, &req)
 			if err != nil {
-				log.Printf("Malformed request from %s: %v", cs.ip, err)
+				cs.logger(s.log, nil).Warnf("Malformed request: %v", err)
 				break
 			}
-			s.setDeadline(cs.conn)
+			s.setSessionDeadline(cs)
 			err = cs.handleMessage(s, e, &req)
 			if err != nil {
 				break
@@ -246,18 +414,25 @@ func (s *StratumServer) handleClient(cs *Session, e *Endpoint) {
 		}
 	}
 	s.removeSession(cs)
+	cs.detach()
+	s.releaseProxySlot(cs)
+	s.admission.releaseAddress(cs.address)
 	cs.conn.Close()
 }
 
 func (cs *Session) handleMessage(s *StratumServer, e *Endpoint, req *JSONRpcReq) error {
 	if req.Id == nil {
 		err := fmt.Errorf("Server disconnect request")
-		log.Println(err)
+		cs.logger(s.log, nil).Warnf("%v", err)
 		return err
 	} else if req.Params == nil {
 		err := fmt.Errorf("Server RPC request params")
-		log.Println(err)
+		cs.logger(s.log, nil).Warnf("%v", err)
 		return err
+	} else if s.isBanned(cs.ip) {
+		return fmt.Errorf("Banned %s", cs.ip)
+	} else if len(cs.address) == 0 && req.Method != "login" {
+		return cs.sendError(req.Id, &ErrorReply{Code: -1, Message: "Unauthenticated"}, false)
 	}
 
 	// Handle RPC methods
@@ -267,7 +442,7 @@ func (cs *Session) handleMessage(s *StratumServer, e *Endpoint, req *JSONRpcReq)
 		var params LoginParams
 		err := json.Unmarshal(*req.Params, &params)
 		if err != nil {
-			log.Println("Unable to parse params")
+			cs.logger(s.log, nil).Warnf("Unable to parse %s params", req.Method)
 			return err
 		}
 		reply, errReply := s.handleLoginRPC(cs, &params)
@@ -279,7 +454,7 @@ func (cs *Session) handleMessage(s *StratumServer, e *Endpoint, req *JSONRpcReq)
 		var params GetJobParams
 		err := json.Unmarshal(*req.Params, &params)
 		if err != nil {
-			log.Println("Unable to parse params")
+			cs.logger(s.log, nil).Warnf("Unable to parse %s params", req.Method)
 			return err
 		}
 		reply, errReply := s.handleGetJobRPC(cs, &params)
@@ -291,7 +466,7 @@ func (cs *Session) handleMessage(s *StratumServer, e *Endpoint, req *JSONRpcReq)
 		var params SubmitParams
 		err := json.Unmarshal(*req.Params, &params)
 		if err != nil {
-			log.Println("Unable to parse params")
+			cs.logger(s.log, nil).Warnf("Unable to parse %s params", req.Method)
 			return err
 		}
 		reply, errReply := s.handleSubmitRPC(cs, &params)
@@ -302,11 +477,23 @@ func (cs *Session) handleMessage(s *StratumServer, e *Endpoint, req *JSONRpcReq)
 	case "keepalived":
 		return cs.sendResult(req.Id, &StatusReply{Status: "KEEPALIVED"})
 	default:
-		errReply := s.handleUnknownRPC(req)
+		errReply := s.handleUnknownRPC(cs, req)
 		return cs.sendError(req.Id, errReply, true)
 	}
 }
 
+// logger adds session and miner fields to log lines
+func (cs *Session) logger(l *logging.Logger, m *Miner) *logging.Logger {
+	fields := logging.Fields{"ip": cs.ip, "port": cs.endpoint.config.Port}
+	if cs.endpoint.unix {
+		fields = logging.Fields{"socket": cs.endpoint.config.Socket}
+	}
+	if m != nil {
+		fields["worker"] = m.id
+	}
+	return l.With(fields)
+}
+
 func (cs *Session) sendResult(id *json.RawMessage, result interface{}) error {
 	cs.Lock()
 	defer cs.Unlock()
@@ -335,10 +522,20 @@ func (cs *Session) sendError(id *json.RawMessage, reply *ErrorReply, drop bool)
 	return nil
 }
 
-func (s *StratumServer) setDeadline(conn *net.TCPConn) {
+func (s *StratumServer) setDeadline(conn net.Conn) {
 	conn.SetDeadline(time.Now().Add(s.timeout))
 }
 
+// setSessionDeadline gives client shorter time to log in than logged in miners get between requests,
+// login time counts from connect, so requests before login don't extend it
+func (s *StratumServer) setSessionDeadline(cs *Session) {
+	if len(cs.address) == 0 {
+		cs.conn.SetDeadline(time.Unix(0, cs.connectedAt*int64(time.Millisecond)).Add(s.loginTimeout))
+	} else {
+		s.setDeadline(cs.conn)
+	}
+}
+
 func (s *StratumServer) registerSession(cs *Session) {
 	s.sessionsMu.Lock()
 	defer s.sessionsMu.Unlock()
@@ -358,12 +555,27 @@ func (s *StratumServer) isActive(cs *Session) bool {
 	return exist
 }
 
-func (s *StratumServer) registerMiner(miner *Miner) {
-	s.miners.Set(miner.id, miner)
+func (s *StratumServer) banIP(ip string) {
+	if s.banTimeout <= 0 {
+		return
+	}
+	s.log.With(logging.Fields{"ip": ip}).Warnf("Banned for %v", s.banTimeout)
+	s.bansMu.Lock()
+	defer s.bansMu.Unlock()
+	s.bans[ip] = util.MakeTimestamp() + int64(s.banTimeout/time.Millisecond)
 }
 
-func (s *StratumServer) removeMiner(id string) {
-	s.miners.Remove(id)
+func (s *StratumServer) isBanned(ip string) bool {
+	s.bansMu.RLock()
+	until, ok := s.bans[ip]
+	s.bansMu.RUnlock()
+	if !ok || until > util.MakeTimestamp() {
+		return ok
+	}
+	s.bansMu.Lock()
+	delete(s.bans, ip)
+	s.bansMu.Unlock()
+	return false
 }
 
 func (s *StratumServer) currentBlockTemplate() *BlockTemplate {
@@ -374,24 +586,86 @@ func (s *StratumServer) currentBlockTemplate() *BlockTemplate {
 }
 
 func (s *StratumServer) checkUpstreams() {
-	candidate := int32(0)
-	backup := false
+	alive := make([]bool, len(s.upstreams))
+	var wg sync.WaitGroup
 
+	// Async rpc calls to not block on rpc timeout of a single upstream
 	for i, v := range s.upstreams {
-		ok, err := v.Check(8, s.config.Address)
-		if err != nil {
-			log.Printf("Upstream %v didn't pass check: %v", v.Name, err)
+		wg.Add(1)
+		go func(i int, v *rpc.RPCClient) {
+			defer wg.Done()
+			ok, err := v.Check(8, s.config.Address)
+			if err != nil {
+				s.upstreamLog.With(logging.Fields{"upstream": v.Name}).Warnf("Upstream didn't pass check: %v", err)
+			}
+			alive[i] = ok
+		}(i, v)
+	}
+	wg.Wait()
+
+	bestHeight := int64(0)
+	for i, v := range s.upstreams {
+		info := v.Info()
+		if !alive[i] || info == nil {
+			continue
 		}
-		if ok && !backup {
-			candidate = int32(i)
-			backup = true
+		if info.Height > bestHeight {
+			bestHeight = info.Height
 		}
+		if info.TargetHeight > bestHeight {
+			bestHeight = info.TargetHeight
+		}
+	}
+
+	eligible := func(i int) bool {
+		info := s.upstreams[i].Info()
+		if !alive[i] || info == nil || !info.Synced() {
+			return false
+		}
+		return bestHeight-info.Height <= s.config.UpstreamMaxHeightLag
 	}
 
-	if s.upstream != candidate {
-		log.Printf("Switching to %v upstream", s.upstreams[candidate].Name)
-		atomic.StoreInt32(&s.upstream, candidate)
+	candidate := -1
+	for i, v := range s.upstreams {
+		if !eligible(i) {
+			continue
+		}
+		if candidate < 0 || betterUpstream(v, s.upstreams[candidate], true) {
+			candidate = i
+		}
+	}
+
+	current := int(atomic.LoadInt32(&s.upstream))
+	if candidate < 0 {
+		s.upstreamLog.With(logging.Fields{"upstream": s.upstreams[current].Name, "height": bestHeight}).Warnf("No eligible upstream, staying on current one")
+		return
+	}
+	if candidate == current {
+		return
+	}
+	// Don't hop between equally good upstreams due to latency jitter
+	if eligible(current) && !betterUpstream(s.upstreams[candidate], s.upstreams[current], false) {
+		return
+	}
+
+	s.upstreamLog.With(logging.Fields{"upstream": s.upstreams[candidate].Name, "height": s.upstreams[candidate].Height()}).Infof("Switching upstream")
+	atomic.StoreInt32(&s.upstream, int32(candidate))
+	s.bus.Publish(s.coin.name, events.UpstreamSwitch, &events.UpstreamSwitchData{From: s.upstreams[current].Name, To: s.upstreams[candidate].Name, Height: s.upstreams[candidate].Height()})
+
+	// Immediately fetch BT from new upstream and send new jobs
+	s.refreshBlockTemplate(true)
+}
+
+// betterUpstream reports whether upstream a is preferred over b: lower priority value first,
+// then greater chain height and then, if enabled, lower RPC latency
+func betterUpstream(a, b *rpc.RPCClient, byLatency bool) bool {
+	if a.Priority != b.Priority {
+		return a.Priority < b.Priority
+	}
+	if a.Height() != b.Height() {
+		return a.Height() > b.Height()
 	}
+	return byLatency && atomic.LoadInt64(&a.Latency) < atomic.LoadInt64(&b.Latency)
 }
 
 func (s *StratumServer) rpc() *rpc.RPCClient {
@@ -0,0 +1,84 @@
+package stratum
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Bounded pool of workers for CPU heavy share validation
+type shareValidator struct {
+	waitTotal int64
+	processed int64
+	rejected  int64
+	shed      int64
+	workers   int
+	policy    string
+	queue     chan *validationTask
+}
+
+type validationTask struct {
+	queuedAt time.Time
+	fn       func()
+	done     chan struct{}
+}
+
+const (
+	rejectPolicy = "reject"
+	shedPolicy   = "shed"
+)
+
+func newShareValidator(workers, queueSize int, policy string) *shareValidator {
+	if policy != shedPolicy {
+		policy = rejectPolicy
+	}
+	v := &shareValidator{workers: workers, policy: policy, queue: make(chan *validationTask, queueSize)}
+	for i := 0; i < workers; i++ {
+		go v.work()
+	}
+	return v
+}
+
+func (v *shareValidator) work() {
+	for task := range v.queue {
+		atomic.AddInt64(&v.waitTotal, int64(time.Since(task.queuedAt)/time.Microsecond))
+		task.fn()
+		atomic.AddInt64(&v.processed, 1)
+		close(task.done)
+	}
+}
+
+// run executes fn on a worker and waits for it, returns false if server is too busy to accept the task.
+// Under shed policy tasks of untrusted miners are refused once queue is half full.
+func (v *shareValidator) run(untrusted bool, fn func()) bool {
+	if v.policy == shedPolicy && untrusted && cap(v.queue) > 0 && len(v.queue) >= cap(v.queue)/2 {
+		atomic.AddInt64(&v.shed, 1)
+		return false
+	}
+	task := &validationTask{queuedAt: time.Now(), fn: fn, done: make(chan struct{})}
+	select {
+	case v.queue <- task:
+	default:
+		atomic.AddInt64(&v.rejected, 1)
+		return false
+	}
+	<-task.done
+	return true
+}
+
+func (v *shareValidator) stats() map[string]interface{} {
+	processed := atomic.LoadInt64(&v.processed)
+	avgWait := float64(0)
+	if processed > 0 {
+		avgWait = float64(atomic.LoadInt64(&v.waitTotal)) / float64(processed) / 1000
+	}
+	return map[string]interface{}{
+		"workers":   v.workers,
+		"policy":    v.policy,
+		"queued":    len(v.queue),
+		"queueSize": cap(v.queue),
+		"processed": processed,
+		"rejected":  atomic.LoadInt64(&v.rejected),
+		"shed":      atomic.LoadInt64(&v.shed),
+		"avgWait":   avgWait,
+	}
+}
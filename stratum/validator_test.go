@@ -0,0 +1,67 @@
+package stratum
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShareValidator(t *testing.T) {
+	tests := []struct {
+		policy   string
+		shed     int64
+		rejected int64
+	}{
+		// Untrusted miner is refused once queue is half full
+		{shedPolicy, 1, 1},
+		{rejectPolicy, 0, 1},
+		{"", 0, 1},
+	}
+	for i, tt := range tests {
+		v := newShareValidator(1, 4, tt.policy)
+		var wg sync.WaitGroup
+		var accepted int64
+		submit := func(untrusted bool, fn func()) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if v.run(untrusted, fn) {
+					atomic.AddInt64(&accepted, 1)
+				}
+			}()
+		}
+		wait := func(cond func() bool) {
+			for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(time.Millisecond) {
+				if time.Now().After(deadline) {
+					t.Fatalf("#%d: timed out, queued %v", i, len(v.queue))
+				}
+			}
+		}
+
+		// Keep the only worker busy
+		started, release := make(chan struct{}), make(chan struct{})
+		submit(false, func() { close(started); <-release })
+		<-started
+		submit(false, func() {})
+		submit(false, func() {})
+		wait(func() bool { return len(v.queue) == 2 })
+
+		submit(true, func() {})
+		wait(func() bool { return atomic.LoadInt64(&v.shed) == 1 || len(v.queue) == 3 })
+		for len(v.queue) < cap(v.queue) {
+			n := len(v.queue)
+			submit(false, func() {})
+			wait(func() bool { return len(v.queue) > n })
+		}
+		if v.run(false, func() {}) {
+			t.Errorf("#%d: task is accepted to full queue", i)
+		}
+
+		close(release)
+		wg.Wait()
+		if v.shed != tt.shed || v.rejected != tt.rejected || accepted != 5 || v.processed != 5 {
+			t.Errorf("#%d: got shed %v, rejected %v, accepted %v, processed %v", i, v.shed, v.rejected, accepted, v.processed)
+		}
+	}
+}
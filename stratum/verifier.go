@@ -0,0 +1,84 @@
+package stratum
+
+import (
+	"encoding/hex"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sammy007/monero-stratum/util"
+)
+
+// Verifies shares by asking upstream daemon to compute PoW hash with calc_pow,
+// for nodes where native hashing library doesn't support current PoW.
+// Every share blob has its own nonce, so hashes are never cached.
+type daemonVerifier struct {
+	sync.Mutex
+	calls      int64
+	limited    int64
+	errors     int64
+	rateLimit  int
+	tokens     float64
+	refilledAt int64
+}
+
+const daemonVerifierName = "daemon"
+
+var errRateLimited = errors.New("Verification rate limit exceeded")
+
+func newDaemonVerifier(rateLimit int) *daemonVerifier {
+	return &daemonVerifier{
+		rateLimit:  rateLimit,
+		tokens:     float64(rateLimit),
+		refilledAt: util.MakeTimestamp(),
+	}
+}
+
+// hash returns PoW hash of block blob, block candidates are never rate limited.
+// Failed call says nothing about health of upstream, so it is not marked sick.
+func (v *daemonVerifier) hash(s *StratumServer, t *BlockTemplate, blob []byte, candidate bool) ([]byte, error) {
+	if !candidate && !v.allow() {
+		atomic.AddInt64(&v.limited, 1)
+		return nil, errRateLimited
+	}
+
+	atomic.AddInt64(&v.calls, 1)
+	reply, err := s.rpc().CalcPow(t.buffer[0], t.height, hex.EncodeToString(blob), t.seedHash)
+	var hashBytes []byte
+	if err == nil {
+		hashBytes, err = hex.DecodeString(reply)
+	}
+	if err != nil {
+		atomic.AddInt64(&v.errors, 1)
+		return nil, err
+	}
+	return hashBytes, nil
+}
+
+// allow takes a token from bucket refilled with rateLimit tokens per second
+func (v *daemonVerifier) allow() bool {
+	if v.rateLimit <= 0 {
+		return true
+	}
+	v.Lock()
+	defer v.Unlock()
+	now := util.MakeTimestamp()
+	v.tokens += float64(now-v.refilledAt) * float64(v.rateLimit) / 1000
+	if v.tokens > float64(v.rateLimit) {
+		v.tokens = float64(v.rateLimit)
+	}
+	v.refilledAt = now
+	if v.tokens < 1 {
+		return false
+	}
+	v.tokens--
+	return true
+}
+
+func (v *daemonVerifier) stats() map[string]interface{} {
+	return map[string]interface{}{
+		"calls":   atomic.LoadInt64(&v.calls),
+		"limited": atomic.LoadInt64(&v.limited),
+		"errors":  atomic.LoadInt64(&v.errors),
+	}
+}
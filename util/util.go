@@ -31,6 +31,21 @@ func GetTargetHex(diff int64) string {
 	return targetHex
 }
 
+// GetTargetDifficulty converts 4 or 8 bytes little-endian stratum job target to difficulty
+func GetTargetDifficulty(targetHex string) (*big.Int, bool) {
+	buff, err := hex.DecodeString(targetHex)
+	if err != nil || (len(buff) != 4 && len(buff) != 8) {
+		return nil, false
+	}
+	padded := make([]byte, 32)
+	copy(padded, reverse(buff))
+	target := new(big.Int).SetBytes(padded)
+	if target.Cmp(new(big.Int)) == 0 {
+		return nil, false
+	}
+	return target.Div(Diff1, target), true
+}
+
 func GetHashDifficulty(hashBytes []byte) (*big.Int, bool) {
 	diff := new(big.Int)
 	diff.SetBytes(reverse(hashBytes))
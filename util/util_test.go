@@ -19,6 +19,23 @@ func TestGetTargetHex(t *testing.T) {
 	}
 }
 
+func TestGetTargetDifficulty(t *testing.T) {
+	diff, ok := GetTargetDifficulty("6e128300")
+	if !ok || diff.Int64() != 500 {
+		t.Error("Invalid diff")
+	}
+
+	diff, ok = GetTargetDifficulty("7b5e0400")
+	if !ok || diff.Int64() != 15000 {
+		t.Error("Invalid diff")
+	}
+
+	diff, ok = GetTargetDifficulty("00000000")
+	if ok || diff != nil {
+		t.Error("Must be no result and not ok")
+	}
+}
+
 func TestGetHashDifficulty(t *testing.T) {
 	hash := "8e3c1865f22801dc3df0a688da80701e2390e7838e65c142604cc00eafe34000"
 	hashBytes, _ := hex.DecodeString(hash)
@@ -1,59 +1,144 @@
 HandlebarsIntl.registerWith(Handlebars);
 
-$(function() {
-	switch (location.hash) {
-	case '#blocks':
-		window.homeTab = false;
-		break;
-	default:
-		window.homeTab = true;
+// Inline SVG line chart of hashrate points
+Handlebars.registerHelper('hashrateChart', function(points, options) {
+	var width = options.hash.width || 600, height = options.hash.height || 80;
+	if (!points || points.length < 2) {
+		return '';
+	}
+	var max = 0;
+	$.each(points, function(i, p) {
+		max = Math.max(max, p.hashrate);
+	});
+	var coords = $.map(points, function(p, i) {
+		var x = i * width / (points.length - 1);
+		var y = max > 0 ? height - p.hashrate * (height - 2) / max - 1 : height - 1;
+		return x.toFixed(1) + ',' + y.toFixed(1);
+	});
+	return new Handlebars.SafeString(
+		'<svg class="chart" viewBox="0 0 ' + width + ' ' + height + '" preserveAspectRatio="none">' +
+		'<polyline points="' + coords.join(' ') + '"/></svg>');
+});
+
+Handlebars.registerHelper('formatBytes', function(bytes) {
+	var units = ['B', 'KiB', 'MiB', 'GiB'], i = 0;
+	while (bytes >= 1024 && i < units.length - 1) {
+		bytes /= 1024;
+		i++;
+	}
+	return (i > 0 ? bytes.toFixed(1) : bytes) + ' ' + units[i];
+});
+
+Handlebars.registerHelper('formatDuration', function(seconds) {
+	var units = [['d', 86400], ['h', 3600], ['m', 60]];
+	for (var i = 0; i < units.length; i++) {
+		if (seconds >= units[i][1]) {
+			var n = Math.floor(seconds / units[i][1]), rest = seconds - n * units[i][1];
+			var next = units[i + 1];
+			return n + units[i][0] + (next && rest >= next[1] ? ' ' + Math.floor(rest / next[1]) + next[0] : '');
+		}
 	}
+	return Math.round(seconds) + 's';
+});
+
+// Rewards are in atomic units, decimals of coin come with stats
+Handlebars.registerHelper('formatCoins', function(amount, decimals) {
+	return (amount / Math.pow(10, decimals)).toFixed(6);
+});
+
+var pages = {
+	home: { tab: '#homeTab', template: '#stats-template', url: function(coin) { return '/stats/' + coin; } },
+	address: { tab: '#addressTab', template: '#address-template', url: function(coin) { return '/stats/' + coin + '/address/' + encodeURIComponent(window.address); } },
+	blocks: { tab: '#blocksTab', template: '#blocks-template', url: function(coin) { return '/stats/' + coin + '/blocks'; } },
+	upstreams: { tab: '#upstreamsTab', template: '#upstreams-template', url: function(coin) { return '/stats/' + coin + '/upstreams'; } }
+};
+
+$(function() {
 	var userLang = (navigator.language || navigator.userLanguage) || 'en-US';
 	window.intlData = { locales: userLang };
-	var statsSource = $("#stats-template").html();
-	var statsTemplate = Handlebars.compile(statsSource);
-	var blocksSource = $("#blocks-template").html();
-	var blocksTemplate = Handlebars.compile(blocksSource);
-	refreshStats(statsTemplate, blocksTemplate);
-
-	$('#homeTab').on('click', function() {
-		window.homeTab = true;
-		refreshStats(statsTemplate, blocksTemplate);
+	$.each(pages, function(name, page) {
+		page.render = Handlebars.compile($(page.template).html());
+	});
+	route();
+	$(window).on('hashchange', function() {
+		route();
+		refreshStats();
 	});
-	$('#blocksTab').on('click', function() {
-		window.homeTab = false;
-		refreshStats(statsTemplate, blocksTemplate);
+	$('#lookup').on('submit', function(e) {
+		e.preventDefault();
+		location.hash = '#address/' + $.trim($('#lookup input').val());
 	});
-	setInterval(function() {
-		refreshStats(statsTemplate, blocksTemplate);
-	}, 5000)
+	loadPools();
+	setInterval(refreshStats, 5000);
 });
 
-function refreshStats(statsTemplate, blocksTemplate) {
-	$.getJSON("/stats", function(stats) {
+// Pages are picked by location hash: #blocks, #upstreams or #address/<address>
+function route() {
+	var parts = location.hash.replace(/^#/, '').split('/');
+	window.page = pages[parts[0]] ? parts[0] : 'home';
+	window.address = parts.slice(1).join('/');
+	$('#lookup input').val(window.address);
+	$('#lookup').toggleClass('hide', window.page != 'address');
+	$('.nav-pills > li').removeClass('active');
+	$(pages[window.page].tab).parent().addClass('active');
+}
+
+// Show coin tabs if stratum hosts several pools
+function loadPools() {
+	$.getJSON("/pools", function(reply) {
+		window.coin = reply.pools[0];
+		if (reply.pools.length > 1) {
+			$.each(reply.pools, function(i, coin) {
+				var tab = $('<li role="presentation"><a href="#"></a></li>');
+				tab.find('a').text(coin).on('click', function(e) {
+					e.preventDefault();
+					window.coin = coin;
+					$('#coins > li').removeClass('active');
+					tab.addClass('active');
+					refreshStats();
+				});
+				if (i == 0) {
+					tab.addClass('active');
+				}
+				$('#coins').append(tab);
+			});
+			$('#coins').removeClass('hide');
+		}
+		refreshStats();
+	}).fail(function() {
+		$("#alert").removeClass('hide');
+	});
+}
+
+function refreshStats() {
+	if (!window.coin) {
+		return;
+	}
+	var page = pages[window.page];
+	if (window.page == 'address' && !window.address) {
+		$('#stats').html('');
+		return;
+	}
+	$.getJSON(page.url(encodeURIComponent(window.coin)), function(stats) {
 		$("#alert").addClass('hide');
 
-		// Sort miners by ID
+		// Sort miners and workers by ID
 		if (stats.miners) {
 			stats.miners = stats.miners.sort(compareMiners);
 		}
+		if (stats.workers) {
+			stats.workers = stats.workers.sort(compareMiners);
+		}
 		// Reverse sort blocks by height
 		if (stats.blocks) {
 			stats.blocks = stats.blocks.sort(compareBlocks);
 		}
-
-		var html = null;
-		$('.nav-pills > li').removeClass('active');
-
-		if (window.homeTab) {
-			html = statsTemplate(stats, { data: { intl: window.intlData } });
-			$('.nav-pills > li > #homeTab').parent().addClass('active');
-		} else {
-			html = blocksTemplate(stats, { data: { intl: window.intlData } });
-			$('.nav-pills > li > #blocksTab').parent().addClass('active');
+		$('#stats').html(page.render(stats, { data: { intl: window.intlData } }));
+	}).fail(function(xhr) {
+		if (xhr.status == 404 && window.page == 'address') {
+			$('#stats').html('<p class="text-muted">No workers of this address are known.</p>');
+			return;
 		}
-		$('#stats').html(html);
-	}).fail(function() {
 		$("#alert").removeClass('hide');
 	});
 }